@@ -7,7 +7,9 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/khevse/image-resizer/description"
 	"github.com/khevse/image-resizer/service/images"
@@ -16,6 +18,43 @@ import (
 func main() {
 
 	port := flag.String("port", "8000", "server port")
+	self := flag.String("self", "", "base URL of this instance in the cluster (e.g. http://10.0.0.1:8000)")
+	peers := flag.String("peers", "", "comma separated base URLs of all cluster instances, including self")
+	snapshot := flag.String("snapshot", "", "file to save cache on shutdown and to load it on startup")
+	staleWhileRevalidate := flag.Duration("stale-while-revalidate", 0, "time after expiration while the image is sent from cache and refreshed in background")
+	staleIfError := flag.Duration("stale-if-error", 0, "time after expiration while the image is sent from cache if the resource is failing")
+	negativeLifetime := flag.Duration("negative-cache-lifetime", 30*time.Second, "lifetime of cached errors of source images, zero disables the cache")
+	revalidate := flag.Duration("revalidate", time.Hour, "time after expiration while the image is kept in cache to revalidate it by ETag/Last-Modified")
+	minLifetime := flag.Duration("cache-min-lifetime", time.Minute, "min lifetime of image in cache set by the resource")
+	maxLifetime := flag.Duration("cache-max-lifetime", 24*time.Hour, "max lifetime of image in cache set by the resource")
+	fetchConnectTimeout := flag.Duration("fetch-connect-timeout", 5*time.Second, "timeout of connection to resource")
+	fetchHeaderTimeout := flag.Duration("fetch-header-timeout", 10*time.Second, "timeout of waiting for response headers of resource")
+	fetchTimeout := flag.Duration("fetch-timeout", 30*time.Second, "total timeout of loading of source image including retries")
+	fetchRetries := flag.Int("fetch-retries", 2, "number of retries of failed requests to resource")
+	fetchRetryDelay := flag.Duration("fetch-retry-delay", 100*time.Millisecond, "base delay between retries, it's doubled after each retry and randomized")
+	fetchMaxRetryDelay := flag.Duration("fetch-max-retry-delay", 2*time.Second, "max delay between retries")
+	breakerFailures := flag.Int("breaker-failures", 5, "number of consecutive failures of host which open its circuit breaker, zero disables breakers")
+	breakerOpenTimeout := flag.Duration("breaker-open-timeout", 30*time.Second, "time while the circuit breaker is open")
+	maxFetchesPerHost := flag.Int("max-fetches-per-host", 50, "max number of concurrent requests to one host, zero disables the limit")
+	maxSourceSize := flag.Int64("max-source-size", 20*1024*1024, "max size of source image in bytes, zero disables the limit")
+	maxSourcePixels := flag.Uint64("max-source-pixels", 50*1000*1000, "max width*height of source image, zero disables the limit")
+	maxSourceFrames := flag.Int("max-source-frames", 1000, "max number of frames of animated source image, zero disables the limit")
+	maxOutputWidth := flag.Uint("max-output-width", 4096, "max width of resized image, zero disables the limit")
+	maxOutputHeight := flag.Uint("max-output-height", 4096, "max height of resized image, zero disables the limit")
+	origins := flag.String("origins", "", "JSON file with allowed origins of source images, all origins are allowed if it's empty")
+	fileRoot := flag.String("file-root", "", "directory of source images loaded by URLs file:///path/in/root, the file source is disabled if it's empty")
+	fileSymlinks := flag.Bool("file-symlinks", false, "follow symlinks in the file root if their targets are inside it, otherwise they are denied")
+	sinkEndpoint := flag.String("sink-endpoint", "", "base URL of S3 API where resized images are written, the sink is disabled if it's empty")
+	sinkRegion := flag.String("sink-region", "us-east-1", "region of the sink bucket")
+	sinkBucket := flag.String("sink-bucket", "", "bucket of resized images")
+	sinkPrefix := flag.String("sink-prefix", "", "prefix of keys of resized images in the sink bucket")
+	sinkAccessKey := flag.String("sink-access-key", os.Getenv("SINK_ACCESS_KEY"), "access key of the sink bucket")
+	sinkSecretKey := flag.String("sink-secret-key", os.Getenv("SINK_SECRET_KEY"), "secret key of the sink bucket")
+	denyNetworks := flag.String("deny-networks", "", "comma separated denied networks of resources (CIDR or loopback, private, link-local, multicast, unspecified, nat64), all of them are denied if it's empty")
+	allowNetworks := flag.String("allow-networks", "", "comma separated networks of resources which are allowed even if they are denied")
+	signingKeys := flag.String("signing-keys", os.Getenv("SIGNING_KEYS"), "comma separated keys of signed URLs in format id:secret, the URLs aren't checked if it's empty")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "token of admin API, the API is disabled if it's empty")
+	flag.Parse()
 
 	log.Printf(
 		"Starting the service...\ncommit: %s, build time: %s, release: %s",
@@ -25,12 +64,101 @@ func main() {
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
+	cfg := images.DefaultConfig()
+	cfg.AdminToken = *adminToken
+	cfg.StaleWhileRevalidate = *staleWhileRevalidate
+	cfg.StaleIfError = *staleIfError
+	cfg.NegativeCacheLifetime = *negativeLifetime
+	cfg.CacheMinLifetime = *minLifetime
+	cfg.CacheMaxLifetime = *maxLifetime
+	cfg.Revalidate = *revalidate
+	cfg.FetchConnectTimeout = *fetchConnectTimeout
+	cfg.FetchHeaderTimeout = *fetchHeaderTimeout
+	cfg.FetchTimeout = *fetchTimeout
+	cfg.FetchRetries = *fetchRetries
+	cfg.FetchRetryDelay = *fetchRetryDelay
+	cfg.FetchMaxRetryDelay = *fetchMaxRetryDelay
+	cfg.BreakerFailures = *breakerFailures
+	cfg.BreakerOpenTimeout = *breakerOpenTimeout
+	cfg.MaxFetchesPerHost = *maxFetchesPerHost
+	cfg.MaxSourceSize = *maxSourceSize
+	cfg.MaxSourcePixels = *maxSourcePixels
+	cfg.MaxSourceFrames = *maxSourceFrames
+	cfg.MaxOutputWidth = *maxOutputWidth
+	cfg.MaxOutputHeight = *maxOutputHeight
+	cfg.FileRoot = *fileRoot
+	cfg.FileSymlinks = *fileSymlinks
+
+	if *sinkEndpoint != "" {
+		cfg.Sink = &images.S3Config{
+			Endpoint: *sinkEndpoint,
+			Region:   *sinkRegion,
+			Bucket:   *sinkBucket,
+			Prefix:   *sinkPrefix,
+		}
+		cfg.Sink.AccessKey = *sinkAccessKey
+		cfg.Sink.SecretKey = *sinkSecretKey
+	}
+
+	if *signingKeys != "" {
+		cfg.SigningKeys = make(map[string][]byte)
+		for _, item := range strings.Split(*signingKeys, ",") {
+			parts := strings.SplitN(item, ":", 2)
+			if len(parts) != 2 || parts[1] == "" {
+				log.Fatal("invalid signing key: ", parts[0])
+			}
+			cfg.SigningKeys[parts[0]] = []byte(parts[1])
+		}
+	}
+
+	if *origins != "" {
+		list, err := images.LoadOrigins(*origins)
+		if err != nil {
+			log.Fatal("failed to load origins: ", err)
+		}
+		cfg.Origins = list
+	}
+
+	if *denyNetworks != "" {
+		networks, err := images.ParseNetworks(strings.Split(*denyNetworks, ","))
+		if err != nil {
+			log.Fatal("invalid denied networks: ", err)
+		}
+		cfg.DenyNetworks = networks
+	}
+
+	if *allowNetworks != "" {
+		networks, err := images.ParseNetworks(strings.Split(*allowNetworks, ","))
+		if err != nil {
+			log.Fatal("invalid allowed networks: ", err)
+		}
+		cfg.AllowNetworks = networks
+	}
+
+	handler := images.NewWithConfig(cfg)
+	defer handler.Close()
+
+	if *snapshot != "" {
+		if err := handler.LoadSnapshot(*snapshot); err != nil && !os.IsNotExist(err) {
+			log.Print("ERROR: failed to load cache snapshot: ", err)
+		}
+	}
+
+	if *peers != "" {
+		if err := handler.SetPeers(*self, strings.Split(*peers, ",")...); err != nil {
+			log.Fatal("invalid cluster configuration (-self must be one of -peers): ", err)
+		}
+		log.Print("Cluster mode, peers: ", *peers)
+	}
+
 	srv := &http.Server{
 		Addr:    ":" + *port,
-		Handler: images.New().Mux(),
+		Handler: handler.Mux(),
 	}
 	go func() {
-		log.Fatal(srv.ListenAndServe())
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
 	}()
 
 	log.Print("The service is ready to listen and serve address ", srv.Addr)
@@ -45,5 +173,12 @@ func main() {
 
 	log.Print("The service is shutting down...")
 	srv.Shutdown(context.Background())
+
+	if *snapshot != "" {
+		if err := handler.SaveSnapshot(*snapshot); err != nil {
+			log.Print("ERROR: failed to save cache snapshot: ", err)
+		}
+	}
+
 	log.Print("Done")
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/signature"
+	"github.com/khevse/image-resizer/service/warmup"
+)
+
+func main() {
+
+	service := flag.String("service", "http://localhost:8000", "base URL of the image resizer service")
+	manifest := flag.String("manifest", "-", "manifest file, '-' for stdin")
+	concurrency := flag.Int("concurrency", 4, "max number of concurrent requests")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of one request")
+	signingKey := flag.String("signing-key", os.Getenv("SIGNING_KEY"), "key of signed URLs in format id:secret, the requests aren't signed if it's empty")
+	flag.Parse()
+
+	var key signature.Key
+	if *signingKey != "" {
+		parts := strings.SplitN(*signingKey, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			log.Fatal("invalid signing key: ", parts[0])
+		}
+		key = signature.Key{ID: parts[0], Secret: []byte(parts[1])}
+	}
+
+	var r io.Reader = os.Stdin
+	if *manifest != "-" {
+		f, err := os.Open(*manifest)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer f.Close()
+		r = f
+	}
+
+	tasks, err := warmup.ParseManifest(r)
+	if err != nil {
+		log.Fatal("invalid manifest: ", err)
+	}
+
+	log.Printf("Warm-up of %d images, concurrency %d...", len(tasks), *concurrency)
+
+	client := &http.Client{Timeout: *timeout}
+	report := warmup.Run(context.Background(), client, *service, key, tasks, *concurrency)
+
+	for _, failure := range report.Failures {
+		log.Printf("FAIL %s: %v", failure.Task, failure.Err)
+	}
+
+	log.Printf(
+		"Done: %d images, %d failed, duration %s (min %s, avg %s, max %s)",
+		report.Total, len(report.Failures), report.Duration, report.Min, report.Avg, report.Max,
+	)
+
+	if len(report.Failures) > 0 {
+		os.Exit(1)
+	}
+}
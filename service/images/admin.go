@@ -0,0 +1,220 @@
+package images
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/breaker"
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/peers"
+	"github.com/khevse/image-resizer/service/images/internal/spec"
+)
+
+// AdminStats - counters of the handler and its caches
+type AdminStats struct {
+	Handler       Stats       `json:"handler"`
+	Cache         cache.Stats `json:"cache"`
+	SourceCache   cache.Stats `json:"source_cache"`
+	NegativeCache cache.Stats `json:"negative_cache"`
+
+	Breakers map[string]breaker.HostStats `json:"breakers"` // states of hosts with failures or requests in progress
+}
+
+// AdminEntry - description of cache entry
+type AdminEntry struct {
+	Key     string    `json:"key"`
+	Source  string    `json:"source"`
+	Tags    []string  `json:"tags,omitempty"`
+	Size    int       `json:"size"`
+	Expired time.Time `json:"expired"`
+}
+
+// AdminEntries - entries of the handler caches
+type AdminEntries struct {
+	Cache       []AdminEntry `json:"cache"`
+	SourceCache []AdminEntry `json:"source_cache"`
+}
+
+// AdminPurgeResult - result of purge request
+type AdminPurgeResult struct {
+	Purged int `json:"purged"` // number of removed entries
+}
+
+// adminMux registers admin handlers, they are available only with the admin token
+func (h *Handler) adminMux(mux *http.ServeMux) {
+
+	if h.adminToken == "" {
+		return
+	}
+
+	mux.HandleFunc("/admin/stats", h.authorize(h.AdminStats))
+	mux.HandleFunc("/admin/entries", h.authorize(h.AdminEntries))
+	mux.HandleFunc("/admin/purge", h.authorize(h.AdminPurge))
+}
+
+// AdminStats sends counters of the handler and its caches
+func (h *Handler) AdminStats(w http.ResponseWriter, req *http.Request) {
+	sendJSON(w, AdminStats{
+		Handler:       h.Stats(),
+		Cache:         h.cache.Stats(),
+		SourceCache:   h.sourceCache.Stats(),
+		NegativeCache: h.negativeCache.Stats(),
+		Breakers:      h.breakers.Stats(),
+	})
+}
+
+// AdminEntries sends list of cache entries
+func (h *Handler) AdminEntries(w http.ResponseWriter, req *http.Request) {
+	sendJSON(w, AdminEntries{
+		Cache:       adminEntries(h.cache),
+		SourceCache: adminEntries(h.sourceCache),
+	})
+}
+
+// AdminPurge removes entries from caches by key (key=...), by source URL with all derivatives (url=...)
+// by tag (tag=...) or all entries (all=true). In cluster mode the request is sent to all peers.
+func (h *Handler) AdminPurge(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodPost && req.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := req.URL.Query()
+
+	var res AdminPurgeResult
+
+	switch {
+	case q.Get("key") != "":
+		key := q.Get("key")
+		if h.cache.Delete(key) {
+			res.Purged++
+		}
+		if h.sourceCache.Delete(key) {
+			res.Purged++
+		}
+
+	case q.Get("url") != "":
+		res.Purged = h.purgeSource(spec.CanonicalURL(q.Get("url")))
+
+	case q.Get("tag") != "":
+		tag := q.Get("tag")
+		res.Purged = h.cache.DeleteTag(tag) + h.sourceCache.DeleteTag(tag)
+
+	case q.Get("all") == "true":
+		res.Purged = h.cache.Clear() + h.sourceCache.Clear() + h.negativeCache.Clear()
+
+	default:
+		http.Error(w, "invalid purge request, expected key, url, tag or all", 400)
+		return
+	}
+
+	res.Purged += h.broadcastPurge(req)
+
+	log.Printf("purge %s: %d entries", q.Encode(), res.Purged)
+	sendJSON(w, res)
+}
+
+// purgeSource removes source image and all its derivatives from caches
+func (h *Handler) purgeSource(sourceURL string) int {
+
+	var purged int
+
+	h.cache.Range(func(e cache.Entry) bool {
+		if e.Meta.Source == sourceURL && h.cache.Delete(e.Key) {
+			purged++
+		}
+		return true
+	})
+
+	if h.sourceCache.Delete(cache.NewKey(sourceURL)) {
+		purged++
+	}
+
+	if h.negativeCache.Delete(cache.NewKey(sourceURL)) {
+		purged++
+	}
+
+	return purged
+}
+
+// broadcastPurge sends purge request to other peers, returns number of entries removed by them
+func (h *Handler) broadcastPurge(req *http.Request) int {
+
+	h.peersMu.RLock()
+	pool := h.peers
+	h.peersMu.RUnlock()
+
+	if pool == nil || peers.IsForwarded(req) {
+		return 0
+	}
+
+	var purged int
+	for _, peer := range pool.Peers() {
+		res, err := pool.Forward(peer, req)
+		if err != nil {
+			log.Println("ERROR: failed to send purge request to peer:", err)
+			continue
+		}
+
+		var peerRes AdminPurgeResult
+		if res.StatusCode != http.StatusOK {
+			log.Println("ERROR: failed to purge on peer", peer, res.Status)
+		} else if err := json.NewDecoder(res.Body).Decode(&peerRes); err != nil {
+			log.Println("ERROR: invalid purge response from peer:", err)
+		}
+
+		if err := res.Body.Close(); err != nil {
+			log.Println("ERROR:", err)
+		}
+
+		purged += peerRes.Purged
+	}
+
+	return purged
+}
+
+// authorize checks the admin token (Authorization: Bearer <token>)
+func (h *Handler) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+
+		auth := req.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, req)
+	}
+}
+
+func adminEntries(c *cache.Cache) []AdminEntry {
+
+	list := make([]AdminEntry, 0)
+	c.Range(func(e cache.Entry) bool {
+		list = append(list, AdminEntry{
+			Key:     e.Key,
+			Source:  e.Meta.Source,
+			Tags:    e.Meta.Tags,
+			Size:    e.Size,
+			Expired: e.Expired,
+		})
+		return true
+	})
+
+	return list
+}
+
+func sendJSON(w http.ResponseWriter, v interface{}) {
+
+	w.Header().Set("Content-type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("ERROR: failed to send response:", err)
+	}
+}
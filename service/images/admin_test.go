@@ -0,0 +1,210 @@
+package images
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testAdminToken = "secret"
+
+func TestAdmin(t *testing.T) {
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	cfg := helperConfig()
+	cfg.AdminToken = testAdminToken
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	for _, sourceURL := range []string{source.URL + "/a", source.URL + "/b"} {
+		for _, size := range []string{"10", "20"} {
+			helperResize(t, svr.URL, sourceURL, size, size)
+		}
+	}
+
+	{
+		// test: unauthorized
+		for _, token := range []string{"", "Bearer invalid", testAdminToken} {
+			req, err := http.NewRequest(http.MethodGet, svr.URL+"/admin/stats", nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", token)
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusUnauthorized, res.StatusCode, token)
+			require.NoError(t, res.Body.Close())
+		}
+	}
+
+	{
+		// test: stats
+		var stats AdminStats
+		helperAdmin(t, http.MethodGet, svr.URL+"/admin/stats", &stats)
+		require.Equal(t, Stats{Requests: 4, Loads: 4, SourceCacheHits: 2, SourceLoads: 2}, stats.Handler)
+		require.Equal(t, 4, stats.Cache.Entries)
+		require.Equal(t, int64(0), stats.Cache.Hits)
+		require.Equal(t, 2, stats.SourceCache.Entries)
+		require.True(t, stats.SourceCache.Bytes > 0)
+	}
+
+	{
+		// test: entries
+		var entries AdminEntries
+		helperAdmin(t, http.MethodGet, svr.URL+"/admin/entries", &entries)
+		require.Len(t, entries.Cache, 4)
+		require.Len(t, entries.SourceCache, 2)
+		require.Equal(t, source.URL+"/b", entries.Cache[3].Source)
+	}
+
+	{
+		// test: invalid method
+		req, err := http.NewRequest(http.MethodGet, svr.URL+"/admin/purge?all=true", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+	}
+
+	{
+		// test: purge by URL
+		var res AdminPurgeResult
+		helperAdmin(t, http.MethodPost, svr.URL+"/admin/purge?url="+url.QueryEscape(source.URL+"/a"), &res)
+		require.Equal(t, 3, res.Purged)
+	}
+
+	{
+		// test: purge by key
+		var entries AdminEntries
+		helperAdmin(t, http.MethodGet, svr.URL+"/admin/entries", &entries)
+		require.Len(t, entries.Cache, 2)
+
+		var res AdminPurgeResult
+		helperAdmin(t, http.MethodDelete, svr.URL+"/admin/purge?key="+entries.Cache[0].Key, &res)
+		require.Equal(t, 1, res.Purged)
+	}
+
+	{
+		// test: purge all
+		var res AdminPurgeResult
+		helperAdmin(t, http.MethodPost, svr.URL+"/admin/purge?all=true", &res)
+		require.Equal(t, 2, res.Purged)
+
+		var stats AdminStats
+		helperAdmin(t, http.MethodGet, svr.URL+"/admin/stats", &stats)
+		require.Equal(t, 0, stats.Cache.Entries)
+		require.Equal(t, 0, stats.SourceCache.Entries)
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+
+	h := helperNew()
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	res, err := http.Get(svr.URL + "/admin/stats")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	require.NoError(t, res.Body.Close())
+}
+
+func TestAdminPurgeCluster(t *testing.T) {
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	cfg := helperConfig()
+	cfg.AdminToken = testAdminToken
+
+	handlers := make([]*Handler, 3)
+	servers := make([]*httptest.Server, len(handlers))
+	addrs := make([]string, len(handlers))
+	for i := range handlers {
+		handlers[i] = NewWithConfig(cfg)
+		defer handlers[i].Close()
+
+		servers[i] = httptest.NewServer(handlers[i].Mux())
+		defer servers[i].Close()
+
+		addrs[i] = servers[i].URL
+	}
+
+	for i := range handlers {
+		require.NoError(t, handlers[i].SetPeers(addrs[i], addrs...))
+	}
+
+	var entries int
+	for size := 10; size < 30; size++ {
+		helperResize(t, servers[0].URL, source.URL, strconv.Itoa(size), strconv.Itoa(size))
+	}
+
+	for _, h := range handlers {
+		entries += h.cache.Stats().Entries + h.sourceCache.Stats().Entries
+	}
+
+	var res AdminPurgeResult
+	helperAdmin(t, http.MethodPost, servers[1].URL+"/admin/purge?url="+url.QueryEscape(source.URL), &res)
+	require.Equal(t, entries, res.Purged)
+
+	for _, h := range handlers {
+		require.Equal(t, 0, h.cache.Stats().Entries)
+		require.Equal(t, 0, h.sourceCache.Stats().Entries)
+	}
+}
+
+func helperResize(t *testing.T, svrURL, sourceURL, width, height string) {
+	t.Helper()
+
+	u, err := url.Parse(svrURL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", sourceURL, "width", width, "height", height)
+
+	res, err := http.Get(u.String())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NoError(t, res.Body.Close())
+}
+
+func helperAdmin(t *testing.T, method, u string, out interface{}) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, u, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/json", res.Header.Get("Content-type"))
+
+	defer func() {
+		require.NoError(t, res.Body.Close())
+	}()
+
+	require.NoError(t, json.NewDecoder(res.Body).Decode(out))
+}
@@ -0,0 +1,331 @@
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/picture"
+	"github.com/khevse/image-resizer/service/images/internal/spec"
+	"github.com/khevse/image-resizer/service/images/signature"
+)
+
+// maxBatchSizes - max number of sizes in one batch request
+const maxBatchSizes = 20
+
+type batchSize struct {
+	Width  uint
+	Height uint
+}
+
+func (s batchSize) String() string {
+	return strconv.FormatUint(uint64(s.Width), 10) + "x" + strconv.FormatUint(uint64(s.Height), 10)
+}
+
+// ResizeBatch makes several sizes of image from one source and sends them as multipart/mixed response
+// (e.g. sizes=100x100,300x200,800x600), the sizes owned by other peers are loaded from them
+func (h *Handler) ResizeBatch(w http.ResponseWriter, req *http.Request) {
+
+	atomic.AddInt64(&h.stats.Requests, 1)
+
+	q := req.URL.Query()
+
+	reqURL := q.Get("url")
+	if reqURL == "" {
+		http.Error(w, "invalid resource URL", 400)
+		return
+	}
+
+	if _, err := h.checkScheme(reqURL); err != nil {
+		sendError(w, err)
+		return
+	}
+
+	sizes, err := parseBatchSizes(q.Get("sizes"))
+	if err != nil {
+		http.Error(w, "invalid property sizes: "+err.Error(), 400)
+		return
+	}
+
+	signedSizes := make([]signature.Size, 0, len(sizes))
+	for _, size := range sizes {
+		if err := h.checkOutputSize(size.Width, size.Height); err != nil {
+			sendError(w, err)
+			return
+		}
+
+		signedSizes = append(signedSizes, signature.Size{Width: size.Width, Height: size.Height})
+	}
+
+	reqTags, err := parseTags(q.Get("tags"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if err := h.checkSignature(q, signature.BatchMessage(reqURL, signedSizes, reqTags...)); err != nil {
+		sendError(w, err)
+		return
+	}
+
+	if _, err := h.checkOrigin(spec.CanonicalURL(reqURL)); err != nil {
+		sendError(w, err)
+		return
+	}
+
+	results := make([][]byte, len(sizes))
+	tags := reqTags
+	maxAge := h.cacheLifetime
+	var (
+		img        image.Image
+		srcMeta    cache.Meta
+		sourceName string // the source of loaded image is preferred to the sources of cached images
+		noStore    bool   // the resource forbids storing of some image in shared caches
+	)
+
+	for i, size := range sizes {
+		sizeSpec := spec.New(reqURL, size.Width, size.Height)
+		cacheKey := cache.NewKey(sizeSpec.String())
+
+		if res, ok, err := h.loadFromPeer(req, sizeSpec, cacheKey, reqTags); ok {
+			if err != nil {
+				sendError(w, err)
+				return
+			}
+			results[i] = res.data
+			tags = mergeTags(tags, res.meta.Tags)
+			if sourceName == "" {
+				sourceName = res.meta.SourceName
+			}
+			if res.meta.Lifetime < maxAge {
+				maxAge = res.meta.Lifetime
+			}
+			noStore = noStore || res.meta.NoStore
+			continue
+		}
+
+		if cached, lifetime, _, ok := h.lookup(cacheKey, sizeSpec); ok {
+			results[i] = cached.data
+			if len(reqTags) > 0 {
+				h.cache.AddTags(cacheKey, maxTags, reqTags...)
+			}
+			tags = mergeTags(tags, cached.meta.Tags)
+			if sourceName == "" {
+				sourceName = cached.meta.SourceName
+			}
+			if lifetime < maxAge {
+				maxAge = lifetime
+			}
+			continue
+		}
+
+		if img == nil {
+			// decode source only once for all sizes
+			src, err := h.loadSource(req.Context(), sizeSpec.URL, sizeSpec.RawURL, cache.Meta{})
+			if err != nil {
+				sendError(w, err)
+				return
+			}
+
+			if err := h.checkSource(sizeSpec.URL, src.data); err != nil {
+				sendError(w, err)
+				return
+			}
+
+			for _, size := range sizes[i:] {
+				if err := h.checkResizedSize(src.data, size.Width, size.Height); err != nil {
+					sendError(w, err)
+					return
+				}
+			}
+
+			img, err = picture.Decode(bytes.NewReader(src.data))
+			if err != nil {
+				sendError(w, h.decodeError(sizeSpec.URL, err))
+				return
+			}
+
+			srcMeta = src.meta
+			srcMeta.Tags = addTags(src.meta.Tags, reqTags)
+			tags = mergeTags(tags, srcMeta.Tags)
+			if srcMeta.SourceName != "" {
+				sourceName = srcMeta.SourceName
+			}
+			if srcMeta.Lifetime > 0 && srcMeta.Lifetime < maxAge {
+				maxAge = srcMeta.Lifetime
+			}
+			noStore = noStore || srcMeta.NoStore
+		}
+
+		atomic.AddInt64(&h.stats.Loads, 1)
+
+		out := bytes.NewBuffer(nil)
+		if err := picture.Encode(out, img, size.Width, size.Height); err != nil {
+			http.Error(w, "internal server error:"+err.Error(), 500)
+			return
+		}
+
+		results[i] = out.Bytes()
+		if srcMeta.NoStore {
+			continue
+		}
+
+		if int64(out.Len()) <= atomic.LoadInt64(&h.maxFileSize) {
+			h.cache.Set(cacheKey, results[i], srcMeta)
+		}
+
+		h.store(cacheKey, results[i])
+	}
+
+	mw := multipart.NewWriter(w)
+
+	w.Header().Add("Cache-Control", h.cacheControl(maxAge, noStore))
+	w.Header().Add("Content-type", "multipart/mixed; boundary="+mw.Boundary())
+	setSurrogateKey(w, tags)
+
+	if sourceName != "" {
+		w.Header().Set(SourceHeader, sourceName)
+	}
+
+	for i, size := range sizes {
+		partHeader := make(textproto.MIMEHeader)
+		partHeader.Set("Content-type", "image/jpeg")
+		partHeader.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.jpg"`, size))
+
+		part, err := mw.CreatePart(partHeader)
+		if err != nil {
+			log.Println("ERROR: failed to send image:", err)
+			return
+		}
+
+		if _, err := part.Write(results[i]); err != nil {
+			log.Println("ERROR: failed to send image:", err)
+			return
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		log.Println("ERROR: failed to send image:", err)
+	}
+}
+
+// loadFromPeer loads one size of batch from the peer owning the key by resize request,
+// ok is false if the size must be made locally. The lifetime of result is max-age of the peer response.
+func (h *Handler) loadFromPeer(req *http.Request, reqSpec spec.Spec, key string, tags []string) (res result, ok bool, err error) {
+
+	pool, peer, ok := h.owner(req, key)
+	if !ok {
+		return result{}, false, nil
+	}
+
+	var peerURL string
+	if signingKey, ok := h.peerKey(); ok {
+		peerURL, err = signature.ResizeURL("/resize", signingKey, reqSpec.RawURL, reqSpec.Width, reqSpec.Height, time.Time{}, tags...)
+		if err != nil {
+			return result{}, true, err
+		}
+	} else {
+		q := url.Values{}
+		q.Set("url", reqSpec.RawURL)
+		q.Set("width", strconv.FormatUint(uint64(reqSpec.Width), 10))
+		q.Set("height", strconv.FormatUint(uint64(reqSpec.Height), 10))
+		if len(tags) > 0 {
+			q.Set("tags", strings.Join(tags, ","))
+		}
+		peerURL = "/resize?" + q.Encode()
+	}
+
+	peerReq, err := http.NewRequest(http.MethodGet, peerURL, nil)
+	if err != nil {
+		return result{}, true, err
+	}
+
+	log.Println("load from peer", peer)
+
+	peerRes, err := pool.Forward(peer, peerReq.WithContext(req.Context()))
+	if err != nil {
+		log.Println("ERROR: failed to get image from peer:", err)
+		return result{}, false, nil
+	}
+
+	defer func() {
+		if err := peerRes.Body.Close(); err != nil {
+			log.Println("ERROR:", err)
+		}
+	}()
+
+	data, err := ioutil.ReadAll(peerRes.Body)
+	if err != nil {
+		log.Println("ERROR: failed to get image from peer:", err)
+		return result{}, false, nil
+	}
+
+	if peerRes.StatusCode != http.StatusOK {
+		e := newHTTPError(peerRes.StatusCode, strings.TrimSpace(string(data)))
+		if retryAfter := peerRes.Header.Get("Retry-After"); retryAfter != "" {
+			e.header = http.Header{"Retry-After": {retryAfter}}
+		}
+		return result{}, true, e
+	}
+
+	res.data = data
+	res.meta.Tags = strings.Fields(peerRes.Header.Get("Surrogate-Key"))
+	res.meta.SourceName = peerRes.Header.Get(SourceHeader)
+	res.meta.NoStore = noStore(peerRes.Header)
+	res.meta.Lifetime = h.cacheLifetime
+	if lifetime, ok := parseLifetime(peerRes.Header, h.cache.Now()); ok {
+		res.meta.Lifetime = lifetime
+	}
+
+	return res, true, nil
+}
+
+// parseBatchSizes parses list of sizes in format "100x100,300x200"
+func parseBatchSizes(src string) ([]batchSize, error) {
+
+	if src == "" {
+		return nil, fmt.Errorf("empty list")
+	}
+
+	items := strings.Split(src, ",")
+	if len(items) > maxBatchSizes {
+		return nil, fmt.Errorf("too many sizes, max %d", maxBatchSizes)
+	}
+
+	sizes := make([]batchSize, 0, len(items))
+	for _, item := range items {
+		parts := strings.Split(item, "x")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid size %q", item)
+		}
+
+		width, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q: %v", item, err)
+		}
+
+		height, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q: %v", item, err)
+		}
+
+		if width == 0 || height == 0 {
+			return nil, fmt.Errorf("invalid size %q", item)
+		}
+
+		sizes = append(sizes, batchSize{Width: uint(width), Height: uint(height)})
+	}
+
+	return sizes, nil
+}
@@ -0,0 +1,126 @@
+package images
+
+import (
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeBatch(t *testing.T) {
+
+	var sourceRequests int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+
+		buf := helperNewImage(t, 1000, 1000)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	h := helperNew()
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize/batch")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", source.URL, "sizes", "20x20,30x30,15x15")
+
+	res, err := http.Get(u.String())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "max-age=3600", res.Header.Get("Cache-Control"))
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/mixed", mediaType)
+
+	mr := multipart.NewReader(res.Body, params["boundary"])
+	for _, exp := range []struct {
+		FileName string
+		Bounds   image.Rectangle
+	}{
+		{"20x20.jpg", image.Rect(0, 0, 20, 20)},
+		{"30x30.jpg", image.Rect(0, 0, 30, 30)},
+		{"15x15.jpg", image.Rect(0, 0, 15, 15)},
+	} {
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		require.Equal(t, "image/jpeg", part.Header.Get("Content-type"))
+		require.Equal(t, exp.FileName, part.FileName())
+
+		img, err := jpeg.Decode(part)
+		require.NoError(t, err)
+		require.Equal(t, exp.Bounds, img.Bounds(), exp.FileName)
+	}
+
+	_, err = mr.NextPart()
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, res.Body.Close())
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&sourceRequests))
+
+	{
+		// test: derivatives are in cache
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL, "width", "20", "height", "20")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		data, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, "4180c34b5cba2a4c3a15a623fd177099", helperMD5(t, data))
+	}
+
+	require.Equal(t,
+		Stats{
+			Requests:  2,
+			CacheHits: 1,
+			Loads:     3,
+
+			SourceLoads: 1,
+		},
+		h.Stats())
+}
+
+func TestParseBatchSizes(t *testing.T) {
+
+	sizes, err := parseBatchSizes("100x100,300x200")
+	require.NoError(t, err)
+	require.Equal(t,
+		[]batchSize{
+			{Width: 100, Height: 100},
+			{Width: 300, Height: 200},
+		},
+		sizes)
+
+	for src, errMsg := range map[string]string{
+		"":       "empty list",
+		"100":    `invalid size "100"`,
+		"0x0":    `invalid size "0x0"`,
+		"300x0":  `invalid size "300x0"`,
+		"0x600":  `invalid size "0x600"`,
+		"100x-1": `invalid size "100x-1": strconv.ParseUint: parsing "-1": invalid syntax`,
+		"a1x1":   `invalid size "a1x1": strconv.ParseUint: parsing "a1": invalid syntax`,
+		"1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1,1x1": "too many sizes, max 20",
+	} {
+		_, err := parseBatchSizes(src)
+		require.EqualError(t, err, errMsg, src)
+	}
+}
@@ -0,0 +1,136 @@
+package images
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeBreaker(t *testing.T) {
+
+	var (
+		sourceRequests int32
+		sourceFailing  int32 = 1
+		sourceBlocked  int32 // the source doesn't respond until the request is canceled
+	)
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+
+		if atomic.LoadInt32(&sourceBlocked) > 0 {
+			<-req.Context().Done()
+			return
+		}
+
+		if atomic.LoadInt32(&sourceFailing) > 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	sourceURL, err := url.Parse(source.URL)
+	require.NoError(t, err)
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cfg := helperConfig()
+	cfg.Clock = clk
+	cfg.BreakerFailures = 2
+	cfg.BreakerOpenTimeout = time.Minute
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	resizeURL := func(sourceURL string, expStatus int, expRetryAfter string) {
+		t.Helper()
+
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", sourceURL, "width", "10", "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, expStatus, res.StatusCode)
+		require.Equal(t, expRetryAfter, res.Header.Get("Retry-After"))
+	}
+
+	resize := func(expStatus int, expRetryAfter string) {
+		t.Helper()
+		resizeURL(source.URL, expStatus, expRetryAfter)
+	}
+
+	{
+		// test: the errors of requests aren't failures of hosts
+		resizeURL("http://missing.invalid/a.jpg", http.StatusBadRequest, "")
+		resizeURL("http://missing.invalid/a.jpg", http.StatusBadRequest, "")
+		require.NotContains(t, h.breakers.Stats(), "missing.invalid")
+	}
+
+	resize(http.StatusBadGateway, "")
+	resize(http.StatusBadGateway, "")
+	require.Equal(t, "open", h.breakers.Stats()[sourceURL.Host].State)
+
+	{
+		// test: the requests fail fast while the breaker is open
+		resize(http.StatusServiceUnavailable, "60")
+
+		clk.Advance(30 * time.Second)
+		resize(http.StatusServiceUnavailable, "30")
+
+		require.Equal(t, int32(2), atomic.LoadInt32(&sourceRequests))
+		require.Equal(t, int64(2), h.Stats().BreakerRejects)
+	}
+
+	{
+		// test: the check canceled by client doesn't close the breaker
+		atomic.StoreInt32(&sourceBlocked, 1)
+		clk.Advance(30 * time.Second)
+
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL, "width", "10", "height", "10")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		require.NoError(t, err)
+
+		_, err = http.DefaultClient.Do(req)
+		require.Error(t, err)
+
+		for h.breakers.Stats()[sourceURL.Host].InFlight > 0 {
+			time.Sleep(time.Millisecond) // wait until the request to source is canceled
+		}
+
+		require.Equal(t, "open", h.breakers.Stats()[sourceURL.Host].State)
+		require.Equal(t, int32(3), atomic.LoadInt32(&sourceRequests))
+		atomic.StoreInt32(&sourceBlocked, 0)
+	}
+
+	{
+		// test: the host is recovered
+		atomic.StoreInt32(&sourceFailing, 0)
+
+		resize(http.StatusOK, "")
+		require.Equal(t, int32(4), atomic.LoadInt32(&sourceRequests))
+		require.NotContains(t, h.breakers.Stats(), sourceURL.Host)
+	}
+}
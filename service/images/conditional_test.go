@@ -0,0 +1,89 @@
+package images
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeConditional(t *testing.T) {
+
+	modified := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	h := helperNew()
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", source.URL, "width", "10", "height", "10")
+
+	send := func(method string, header http.Header, expStatus int) (*http.Response, []byte) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, u.String(), nil)
+		require.NoError(t, err)
+		req.Header = header
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, expStatus, res.StatusCode)
+
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+
+		return res, body
+	}
+
+	res, body := send(http.MethodGet, http.Header{}, http.StatusOK)
+	require.NotEmpty(t, body)
+	require.Equal(t, etag(body), res.Header.Get("ETag"))
+	require.Equal(t, modified.Format(http.TimeFormat), res.Header.Get("Last-Modified"))
+
+	tag := res.Header.Get("ETag")
+
+	{
+		// test: If-None-Match
+		res, body := send(http.MethodGet, http.Header{"If-None-Match": {`"other", ` + tag}}, http.StatusNotModified)
+		require.Empty(t, body)
+		require.Equal(t, tag, res.Header.Get("ETag"))
+
+		send(http.MethodGet, http.Header{"If-None-Match": {`"other"`}}, http.StatusOK)
+	}
+
+	{
+		// test: If-Modified-Since
+		_, body := send(http.MethodGet, http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, http.StatusNotModified)
+		require.Empty(t, body)
+
+		send(http.MethodGet, http.Header{"If-Modified-Since": {modified.Add(-time.Second).Format(http.TimeFormat)}}, http.StatusOK)
+	}
+
+	{
+		// test: HEAD
+		res, body := send(http.MethodHead, http.Header{}, http.StatusOK)
+		require.Empty(t, body)
+		require.Equal(t, tag, res.Header.Get("ETag"))
+		require.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+	}
+
+	require.Equal(t, int64(1), h.Stats().Loads)
+}
@@ -0,0 +1,115 @@
+package images
+
+import (
+	"net"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/khevse/image-resizer/service/images/internal/fetch"
+)
+
+// Config of images handler
+type Config struct {
+	CacheMaxFileSize int64         // max size of resized image in cache
+	CacheMaxItems    int           // max number of resized images in cache
+	CacheLifetime    time.Duration // lifetime of resized image in cache, if the resource doesn't set it
+
+	// limits of lifetime set by the resource (Cache-Control and Expires headers)
+	CacheMinLifetime time.Duration
+	CacheMaxLifetime time.Duration
+
+	// StaleWhileRevalidate - time after expiration while the resized image is sent from cache and it's refreshed in background
+	StaleWhileRevalidate time.Duration
+	// StaleIfError - time after expiration while the resized image is sent from cache if the resource is failing
+	StaleIfError time.Duration
+	// Revalidate - time after expiration while the images are kept in cache to revalidate them on resource by ETag/Last-Modified
+	Revalidate time.Duration
+
+	SourceCacheMaxFileSize int64         // max size of source image in cache
+	SourceCacheMaxItems    int           // max number of source images in cache
+	SourceCacheLifetime    time.Duration // lifetime of source image in cache
+
+	NegativeCacheMaxItems int           // max number of failed source images in cache
+	NegativeCacheLifetime time.Duration // lifetime of error of source image in cache, the cache is disabled if it's zero
+
+	FetchConnectTimeout time.Duration // timeout of connection to resource
+	FetchHeaderTimeout  time.Duration // timeout of waiting for response headers of resource
+	FetchTimeout        time.Duration // total timeout of loading of source image including retries
+	FetchRetries        int           // number of retries of failed requests to resource (connection errors, 502, 503, 504)
+	FetchRetryDelay     time.Duration // base delay between retries, it's doubled after each retry and randomized
+	FetchMaxRetryDelay  time.Duration // max delay between retries
+
+	BreakerFailures    int           // number of consecutive failures of host which open its circuit breaker, breakers are disabled if it's zero
+	BreakerOpenTimeout time.Duration // time while the circuit breaker is open
+	MaxFetchesPerHost  int           // max number of concurrent requests to one host, no limit if it's zero
+
+	MaxSourceSize   int64  // max size of source image loaded from resource, no limit if it's zero
+	MaxSourcePixels uint64 // max width*height of source image, no limit if it's zero
+	MaxSourceFrames int    // max number of frames of animated source image, no limit if it's zero
+	MaxOutputWidth  uint   // max width of resized image, no limit if it's zero
+	MaxOutputHeight uint   // max height of resized image, no limit if it's zero
+
+	Origins []Origin // allowed origins of source images, all origins are allowed if it's empty
+
+	Sink *S3Config // bucket where resized images are written by their cache keys, the sink is disabled if it's nil
+
+	FileRoot     string // directory of source images loaded by URLs file:///path/in/root, the file source is disabled if it's empty
+	FileSymlinks bool   // symlinks in the directory are followed if their targets are inside it, otherwise they are denied
+
+	// DenyNetworks - networks of resources which are denied (protection from SSRF),
+	// loopback, private, link-local, multicast, unspecified and NAT64 addresses are denied if it's nil
+	DenyNetworks []*net.IPNet
+	// AllowNetworks - networks of resources which are allowed even if they are denied
+	AllowNetworks []*net.IPNet
+
+	// SigningKeys - keys of signed URLs by their IDs, several keys are used for rotation, URLs aren't checked if it's empty
+	SigningKeys map[string][]byte
+
+	AdminToken string // token of admin API (Authorization: Bearer <token>), the API is disabled if it's empty
+
+	Clock clock.Clock // source of time of caches, the system time if it's nil
+}
+
+// DefaultConfig returns default config of images handler
+func DefaultConfig() Config {
+
+	const MB int64 = 1024 * 1024
+
+	return Config{
+		CacheMaxFileSize: 10 * MB,
+		CacheMaxItems:    50,
+		CacheLifetime:    time.Hour,
+		CacheMinLifetime: time.Minute,
+		CacheMaxLifetime: 24 * time.Hour,
+		Revalidate:       time.Hour,
+
+		SourceCacheMaxFileSize: 20 * MB,
+		SourceCacheMaxItems:    20,
+		SourceCacheLifetime:    time.Hour,
+
+		NegativeCacheMaxItems: 1000,
+		NegativeCacheLifetime: 30 * time.Second,
+
+		FetchConnectTimeout: 5 * time.Second,
+		FetchHeaderTimeout:  10 * time.Second,
+		FetchTimeout:        30 * time.Second,
+		FetchRetries:        2,
+		FetchRetryDelay:     100 * time.Millisecond,
+		FetchMaxRetryDelay:  2 * time.Second,
+
+		BreakerFailures:    5,
+		BreakerOpenTimeout: 30 * time.Second,
+		MaxFetchesPerHost:  50,
+
+		MaxSourceSize:   20 * MB,
+		MaxSourcePixels: 50 * 1000 * 1000,
+		MaxSourceFrames: 1000,
+		MaxOutputWidth:  4096,
+		MaxOutputHeight: 4096,
+	}
+}
+
+// ParseNetworks parses list of CIDR networks or names of networks: loopback, private, link-local, multicast, unspecified, nat64
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	return fetch.ParseNetworks(list)
+}
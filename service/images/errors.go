@@ -0,0 +1,97 @@
+package images
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/breaker"
+)
+
+// httpError - error with HTTP status code
+type httpError struct {
+	code   int
+	msg    string
+	header http.Header // additional headers of response
+
+	negative bool // the error may be stored to negative cache
+	failure  bool // the resource is failing (network error, timeout or 5xx response)
+}
+
+func newHTTPError(code int, msg string) *httpError {
+	return &httpError{code: code, msg: msg}
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+// sourceTooLarge returns error of source image which exceeds the limit of size,
+// the size is the minimal known size of the image.
+func sourceTooLarge(size, limit int64) *httpError {
+
+	e := newHTTPError(http.StatusRequestEntityTooLarge, "source image is too large: "+
+		strconv.FormatInt(size, 10)+" bytes, the limit is "+strconv.FormatInt(limit, 10)+" bytes")
+	e.negative = true
+
+	return e
+}
+
+// isFailure returns true if the error is caused by failure of resource (network error, timeout or 5xx response)
+// or the resource is unavailable, the errors of request or image aren't failures
+func isFailure(err error) bool {
+	e, ok := err.(*httpError)
+	return ok && (e.failure || e.code == http.StatusServiceUnavailable)
+}
+
+// breakerError returns error of request rejected by circuit breaker
+func breakerError(err error) *httpError {
+
+	e := newHTTPError(http.StatusServiceUnavailable, "resource is unavailable:"+err.Error())
+
+	if be, ok := err.(*breaker.Error); ok {
+		// round up, so the header never contains zero
+		seconds := int64((be.RetryAfter + time.Second - 1) / time.Second)
+		e.header = http.Header{"Retry-After": {strconv.FormatInt(seconds, 10)}}
+	}
+
+	return e
+}
+
+// sendError writes error to the response
+func sendError(w http.ResponseWriter, err error) {
+
+	if e, ok := err.(*httpError); ok {
+		for name, values := range e.header {
+			for _, v := range values {
+				w.Header().Add(name, v)
+			}
+		}
+
+		http.Error(w, e.msg, e.code)
+		return
+	}
+
+	http.Error(w, "internal server error:"+err.Error(), http.StatusInternalServerError)
+}
+
+// isTimeout returns true if the error is a network timeout
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+// isConnectionError returns true if the connection to resource failed (e.g. it's refused or reset),
+// the errors of resolving of host aren't failures of resource
+func isConnectionError(err error) bool {
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return false
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
@@ -1,51 +1,292 @@
 package images
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"path/filepath"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/khevse/image-resizer/service/images/internal/breaker"
 	"github.com/khevse/image-resizer/service/images/internal/buffer"
 	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/khevse/image-resizer/service/images/internal/fetch"
+	"github.com/khevse/image-resizer/service/images/internal/flight"
+	"github.com/khevse/image-resizer/service/images/internal/origin"
+	"github.com/khevse/image-resizer/service/images/internal/peers"
 	"github.com/khevse/image-resizer/service/images/internal/picture"
+	"github.com/khevse/image-resizer/service/images/internal/s3"
+	"github.com/khevse/image-resizer/service/images/internal/spec"
+	"github.com/khevse/image-resizer/service/images/signature"
 )
 
 // Handler images server mux object
 type Handler struct {
 	cache         *cache.Cache
+	sourceCache   *cache.Cache
+	negativeCache *cache.Cache
+	client        *http.Client
+	sources       map[string]source // sources of images by scheme of URL, the HTTP source is used for other schemes
+	origins       origin.List
+	originSources map[*Origin]source // sources of images of origins, the HTTP source is used for other origins
+	sink          *s3.Bucket         // bucket of resized images, nil if it's disabled
+	signingKeys   signature.Keys
+	sourceLimits  picture.Limits
+	breakers      *breaker.Set
+	maxSourceSize int64
 	maxFileSize   int64
-	cacheLifetime string
+	adminToken    string
+
+	maxOutputWidth  uint
+	maxOutputHeight uint
+
+	cacheLifetime        time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	negativeLifetime     time.Duration
+	minLifetime          time.Duration
+	maxLifetime          time.Duration
+
+	flight     flight.Group
+	refreshing sync.Map // keys of expired images which are refreshed in background
+	stats      Stats
+	sinkWG     sync.WaitGroup
+	sinkMu     sync.Mutex
+	sinkClosed bool // the images aren't written to the sink after Close
+
+	peers   *peers.Pool
+	peersMu sync.RWMutex
 }
 
-// New images handler
+// Stats - counters of the images handler
+type Stats struct {
+	Requests  int64 `json:"requests"`   // number of resize requests
+	CacheHits int64 `json:"cache_hits"` // number of requests served from cache
+	Loads     int64 `json:"loads"`      // number of images resized
+	Coalesced int64 `json:"coalesced"`  // number of requests which waited for a concurrent identical request
+
+	StaleHits   int64 `json:"stale_hits"`   // number of expired images sent while they are refreshed in background
+	StaleErrors int64 `json:"stale_errors"` // number of expired images sent because the resource is failing
+
+	NegativeHits int64 `json:"negative_hits"` // number of errors sent from negative cache
+
+	SourceCacheHits int64 `json:"source_cache_hits"` // number of source images taken from cache
+	SourceLoads     int64 `json:"source_loads"`      // number of source images loaded from resources
+
+	SourceRevalidations int64 `json:"source_revalidations"` // number of source images which are not modified on resources
+
+	BreakerRejects int64 `json:"breaker_rejects"` // number of requests to resources rejected by circuit breakers or limits
+
+	SinkWrites int64 `json:"sink_writes"` // number of resized images written to the sink bucket
+	SinkErrors int64 `json:"sink_errors"` // number of failed writes to the sink bucket
+}
+
+// New images handler with default config
 func New() *Handler {
+	return NewWithConfig(DefaultConfig())
+}
+
+// NewWithConfig returns images handler
+func NewWithConfig(cfg Config) *Handler {
+
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
+	origins := append(origin.List(nil), cfg.Origins...)
+
+	client := fetch.NewClient(fetch.Config{
+		Deny:           cfg.DenyNetworks,
+		Allow:          cfg.AllowNetworks,
+		CheckRedirect:  originRedirects(origins),
+		ConnectTimeout: cfg.FetchConnectTimeout,
+		HeaderTimeout:  cfg.FetchHeaderTimeout,
+		Timeout:        cfg.FetchTimeout,
+		Retries:        cfg.FetchRetries,
+		RetryDelay:     cfg.FetchRetryDelay,
+		MaxRetryDelay:  cfg.FetchMaxRetryDelay,
+	})
+
+	breakers := breaker.New(breaker.Config{
+		Failures:      cfg.BreakerFailures,
+		OpenTimeout:   cfg.BreakerOpenTimeout,
+		MaxConcurrent: cfg.MaxFetchesPerHost,
+	}, clk)
+
+	h := &Handler{
+		maxFileSize:          cfg.CacheMaxFileSize,
+		cache:                cache.NewWithClock(cfg.CacheMaxFileSize, cfg.CacheMaxItems, cfg.CacheLifetime, time.Second, clk),
+		sourceCache:          cache.NewWithClock(cfg.SourceCacheMaxFileSize, cfg.SourceCacheMaxItems, cfg.SourceCacheLifetime, time.Second, clk),
+		negativeCache:        cache.NewWithClock(cfg.CacheMaxFileSize, cfg.NegativeCacheMaxItems, cfg.NegativeCacheLifetime, time.Second, clk),
+		client:               client,
+		origins:              origins,
+		signingKeys:          cfg.SigningKeys,
+		sourceLimits:         picture.Limits{MaxPixels: cfg.MaxSourcePixels, MaxFrames: cfg.MaxSourceFrames},
+		breakers:             breakers,
+		maxSourceSize:        cfg.MaxSourceSize,
+		maxOutputWidth:       cfg.MaxOutputWidth,
+		maxOutputHeight:      cfg.MaxOutputHeight,
+		cacheLifetime:        cfg.CacheLifetime,
+		staleWhileRevalidate: cfg.StaleWhileRevalidate,
+		staleIfError:         cfg.StaleIfError,
+		negativeLifetime:     cfg.NegativeCacheLifetime,
+		minLifetime:          cfg.CacheMinLifetime,
+		maxLifetime:          cfg.CacheMaxLifetime,
+		adminToken:           cfg.AdminToken,
+	}
+
+	grace := cfg.StaleWhileRevalidate
+	if cfg.StaleIfError > grace {
+		grace = cfg.StaleIfError
+	}
+	if cfg.Revalidate > grace {
+		grace = cfg.Revalidate
+	}
+	if cfg.FileRoot != "" {
+		root, err := filepath.Abs(cfg.FileRoot)
+		if err != nil {
+			root = cfg.FileRoot // it's checked on each request
+		}
+		h.sources = map[string]source{
+			fileScheme: fileSource{h: h, root: root, symlinks: cfg.FileSymlinks},
+		}
+	}
+
+	// the endpoints of storages are set by config, so they aren't checked like resources
+	storageClient := fetch.NewClient(fetch.Config{
+		Deny:           []*net.IPNet{},
+		ConnectTimeout: cfg.FetchConnectTimeout,
+		HeaderTimeout:  cfg.FetchHeaderTimeout,
+		Timeout:        cfg.FetchTimeout,
+		Retries:        cfg.FetchRetries,
+		RetryDelay:     cfg.FetchRetryDelay,
+		MaxRetryDelay:  cfg.FetchMaxRetryDelay,
+	})
+
+	for i := range h.origins {
+		o := &h.origins[i]
 
-	const MB int64 = 1024 * 1024 * 1024
+		src := h.newOriginSource(o, storageClient)
+		if src == nil {
+			continue
+		}
 
-	cacheLifetime := time.Hour
+		if h.originSources == nil {
+			h.originSources = make(map[*Origin]source)
+		}
+		h.originSources[o] = src
+	}
 
-	return &Handler{
-		maxFileSize:   MB,
-		cache:         cache.New(MB, 50, cacheLifetime, time.Second),
-		cacheLifetime: "max-age=" + strconv.FormatInt(int64(cacheLifetime.Seconds()), 10),
+	if cfg.Sink != nil {
+		h.sink = s3.New(*cfg.Sink, storageClient)
 	}
+
+	h.cache.SetGrace(grace)
+	h.sourceCache.SetGrace(cfg.Revalidate)
+
+	return h
 }
 
-// Close internal cache
+// Close internal caches, it waits for writes to the sink bucket
 func (h *Handler) Close() error {
+
+	h.sinkMu.Lock()
+	h.sinkClosed = true
+	h.sinkMu.Unlock()
+
+	h.sinkWG.Wait()
+
+	if err := h.negativeCache.Close(); err != nil {
+		return err
+	}
+
+	if err := h.sourceCache.Close(); err != nil {
+		return err
+	}
+
 	return h.cache.Close()
 }
 
+// Stats returns snapshot of the handler counters
+func (h *Handler) Stats() Stats {
+	return Stats{
+		Requests:  atomic.LoadInt64(&h.stats.Requests),
+		CacheHits: atomic.LoadInt64(&h.stats.CacheHits),
+		Loads:     atomic.LoadInt64(&h.stats.Loads),
+		Coalesced: atomic.LoadInt64(&h.stats.Coalesced),
+
+		StaleHits:   atomic.LoadInt64(&h.stats.StaleHits),
+		StaleErrors: atomic.LoadInt64(&h.stats.StaleErrors),
+
+		NegativeHits: atomic.LoadInt64(&h.stats.NegativeHits),
+
+		SourceCacheHits: atomic.LoadInt64(&h.stats.SourceCacheHits),
+		SourceLoads:     atomic.LoadInt64(&h.stats.SourceLoads),
+
+		SourceRevalidations: atomic.LoadInt64(&h.stats.SourceRevalidations),
+
+		BreakerRejects: atomic.LoadInt64(&h.stats.BreakerRejects),
+
+		SinkWrites: atomic.LoadInt64(&h.stats.SinkWrites),
+		SinkErrors: atomic.LoadInt64(&h.stats.SinkErrors),
+	}
+}
+
+// ServeStats sends handler counters in JSON format, the stats of caches are sent by admin API
+func (h *Handler) ServeStats(w http.ResponseWriter, req *http.Request) {
+	sendJSON(w, h.Stats())
+}
+
+// SetPeers enables cluster mode: each key is served by the peer owning it on the consistent hash ring.
+// self is the base URL of the current instance, peers is the full list of base URLs (including self).
+// It returns error if self isn't in the list, otherwise the keys of self would be forwarded to itself.
+func (h *Handler) SetPeers(self string, list ...string) error {
+
+	self = strings.TrimRight(self, "/")
+
+	var found bool
+	for _, peer := range list {
+		if self != "" && strings.TrimRight(peer, "/") == self {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return errors.New("the current instance " + strconv.Quote(self) + " isn't in the list of peers")
+	}
+
+	pool := peers.NewPool(self)
+	pool.Set(list...)
+
+	h.peersMu.Lock()
+	h.peers = pool
+	h.peersMu.Unlock()
+
+	return nil
+}
+
 // Mux returns server mux of images server
 func (h *Handler) Mux() *http.ServeMux {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/resize", h.Resize)
+	mux.HandleFunc("/resize/batch", h.ResizeBatch)
+	mux.HandleFunc("/stats", h.ServeStats)
+	h.adminMux(mux)
 
 	return mux
 }
@@ -53,82 +294,467 @@ func (h *Handler) Mux() *http.ServeMux {
 // Resize image
 func (h *Handler) Resize(w http.ResponseWriter, req *http.Request) {
 
+	atomic.AddInt64(&h.stats.Requests, 1)
+
 	q := req.URL.Query()
 
-	reqURL := q.Get("url")
-	reqWidthStr := q.Get("width")
-	reqHeightStr := q.Get("height")
+	reqSpec, err := spec.Parse(q)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
 
-	if reqURL == "" {
-		http.Error(w, "invalid resource URL", 400)
+	if _, err := h.checkScheme(reqSpec.RawURL); err != nil {
+		sendError(w, err)
 		return
 	}
 
-	reqWidth, err := strconv.Atoi(reqWidthStr)
+	reqTags, err := parseTags(q.Get("tags"))
 	if err != nil {
-		http.Error(w, "invalid property width: "+err.Error(), 400)
+		http.Error(w, err.Error(), 400)
 		return
-	} else if reqWidth <= 0 {
-		http.Error(w, "invalid property width", 400)
+	}
+
+	if err := h.checkOutputSize(reqSpec.Width, reqSpec.Height); err != nil {
+		sendError(w, err)
 		return
 	}
 
-	reqHeight, err := strconv.Atoi(reqHeightStr)
-	if err != nil {
-		http.Error(w, "invalid property height: "+err.Error(), 400)
+	if err := h.checkSignature(q, signature.ResizeMessage(reqSpec.RawURL, reqSpec.Width, reqSpec.Height, reqTags...)); err != nil {
+		sendError(w, err)
 		return
-	} else if reqHeight <= 0 {
-		http.Error(w, "invalid property height", 400)
+	}
+
+	if _, err := h.checkOrigin(reqSpec.URL); err != nil {
+		sendError(w, err)
 		return
 	}
 
-	w.Header().Add("Cache-Control", h.cacheLifetime)
+	cacheKey := cache.NewKey(reqSpec.String())
 
-	cacheKey := cache.NewKey(reqURL + reqWidthStr + reqHeightStr)
-	cacheVal, ok := h.cache.Get(cacheKey)
-	if ok {
-		log.Println("send image from cache")
+	if h.forward(w, req, cacheKey) {
+		return
+	}
 
-		_, err := io.Copy(w, bytes.NewReader(cacheVal))
-		if err != nil {
-			http.Error(w, "failed to send request:"+err.Error(), 500)
-			return
+	img, maxAge, stale, ok := h.lookup(cacheKey, reqSpec)
+	if !ok {
+		maxAge = h.cacheLifetime
+
+		img, err = h.loadOnce(req.Context(), cacheKey, reqSpec, reqTags, stale.meta)
+		if err == nil && img.meta.Lifetime > 0 {
+			maxAge = img.meta.Lifetime
 		}
 
-	} else {
-		log.Println("send from resource")
+		if isFailure(err) && stale.data != nil && h.cache.Now().Before(stale.expired.Add(h.staleIfError)) {
+			log.Println("send stale image from cache, the resource is failing:", err)
+			atomic.AddInt64(&h.stats.StaleErrors, 1)
 
-		reqForLoad, err := http.NewRequest(http.MethodGet, reqURL, nil)
-		if err != nil {
-			http.Error(w, "failed to create request:"+err.Error(), 400)
-			return
+			img, err = stale.result, nil
+			maxAge = 0
 		}
 
-		res, err := http.DefaultClient.Do(reqForLoad)
 		if err != nil {
-			http.Error(w, "failed to send request:"+err.Error(), 400)
+			sendError(w, err)
 			return
 		}
+	}
+
+	if len(reqTags) > 0 {
+		// the image may be cached by request with other tags
+		h.cache.AddTags(cacheKey, maxTags, reqTags...)
+		img.meta.Tags = addTags(img.meta.Tags, reqTags)
+	}
+
+	w.Header().Add("Cache-Control", h.cacheControl(maxAge, img.meta.NoStore))
+	w.Header().Add("Content-type", "image/jpeg")
+	w.Header().Add("ETag", etag(img.data))
+	setSurrogateKey(w, img.meta.Tags)
+
+	if img.meta.SourceName != "" {
+		w.Header().Set(SourceHeader, img.meta.SourceName)
+	}
+
+	// the conditional and HEAD requests are answered without body
+	http.ServeContent(w, req, "", img.meta.Modified, bytes.NewReader(img.data))
+}
+
+// staleImage - expired image from cache, it's sent if the resource is failing
+type staleImage struct {
+	result
+	expired time.Time
+}
+
+// lookup returns image from cache with its max-age, the image expired within stale-while-revalidate period
+// is returned with zero max-age and it's refreshed in background. If ok is false, the image must be loaded
+// and the expired image is returned as stale (if any).
+func (h *Handler) lookup(cacheKey string, reqSpec spec.Spec) (img result, maxAge time.Duration, stale staleImage, ok bool) {
+
+	data, meta, expired, found := h.cache.LookupStale(cacheKey)
+	now := h.cache.Now()
+
+	switch {
+	case found && now.Before(expired):
+		log.Println("send image from cache")
+		atomic.AddInt64(&h.stats.CacheHits, 1)
+
+		return result{data: data, meta: meta}, expired.Sub(now), staleImage{}, true
+
+	case found && now.Before(expired.Add(h.staleWhileRevalidate)):
+		log.Println("send stale image from cache")
+		atomic.AddInt64(&h.stats.StaleHits, 1)
+
+		h.startRefresh(cacheKey, reqSpec)
+
+		return result{data: data, meta: meta}, 0, staleImage{}, true
+
+	case found:
+		return result{}, 0, staleImage{result: result{data: data, meta: meta}, expired: expired}, false
+	}
+
+	return result{}, 0, staleImage{}, false
+}
+
+// etag returns strong entity tag of the image
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// loadOnce loads image, concurrent identical requests wait for the first one,
+// the loading is canceled when all requests are canceled.
+func (h *Handler) loadOnce(ctx context.Context, cacheKey string, reqSpec spec.Spec, tags []string, validators cache.Meta) (result, error) {
+
+	val, shared, err := h.flight.DoContext(ctx, cacheKey, func(ctx context.Context) (interface{}, error) {
+		if data, meta, ok := h.cache.Lookup(cacheKey); ok {
+			return result{data: data, meta: meta}, nil // the previous identical request has already finished
+		}
+		return h.load(ctx, cacheKey, reqSpec, tags, validators)
+	})
+	if err != nil {
+		logPanic(err)
+		return result{}, err
+	}
+
+	if shared {
+		log.Println("send image from coalesced request")
+		atomic.AddInt64(&h.stats.Coalesced, 1)
+	}
 
+	return val.(result), nil
+}
+
+// startRefresh starts refresh of expired image in background,
+// it returns false if the image is already refreshed
+func (h *Handler) startRefresh(cacheKey string, reqSpec spec.Spec) bool {
+
+	if _, running := h.refreshing.LoadOrStore(cacheKey, struct{}{}); running {
+		return false
+	}
+
+	go func() {
+		defer h.refreshing.Delete(cacheKey)
 		defer func() {
-			if err := res.Body.Close(); err != nil {
-				log.Println("ERROR:", err)
+			if r := recover(); r != nil {
+				log.Printf("ERROR: panic on refresh of image: %v\n%s", r, debug.Stack())
 			}
 		}()
 
-		srcReader := bufio.NewReaderSize(res.Body, 512)
+		h.refresh(cacheKey, reqSpec)
+	}()
 
-		buf := buffer.New(int(atomic.LoadInt64(&h.maxFileSize)))
-		wr := io.MultiWriter(buf, w)
-		if err := picture.Resize(wr, srcReader, uint(reqWidth), uint(reqHeight)); err != nil {
-			http.Error(w, "internal server error:"+err.Error(), 500)
-			return
+	return true
+}
+
+// refresh reloads expired image
+func (h *Handler) refresh(cacheKey string, reqSpec spec.Spec) {
+
+	_, _, err := h.flight.Do(cacheKey, func() (interface{}, error) {
+		if data, meta, ok := h.cache.Lookup(cacheKey); ok {
+			return result{data: data, meta: meta}, nil // already refreshed
+		}
+
+		// keep tags of the expired image
+		_, meta, _, _ := h.cache.LookupStale(cacheKey)
+
+		return h.load(context.Background(), cacheKey, reqSpec, meta.Tags, meta)
+	})
+	if err != nil {
+		logPanic(err)
+		log.Println("ERROR: failed to refresh image:", err)
+	}
+}
+
+// logPanic logs stack of panic on loading of image
+func logPanic(err error) {
+	var e *flight.PanicError
+	if errors.As(err, &e) {
+		log.Printf("ERROR: %v\n%s", e, e.Stack)
+	}
+}
+
+// decodeError returns error of invalid source image and stores it to negative cache
+func (h *Handler) decodeError(sourceURL string, err error) error {
+
+	e := newHTTPError(500, "internal server error:"+err.Error())
+	e.negative = true
+	h.addNegative(sourceURL, e)
+
+	return e
+}
+
+// checkSource returns error if the source image can't be decoded or exceeds the limits, the error is stored to negative cache
+func (h *Handler) checkSource(sourceURL string, data []byte) error {
+
+	err := picture.Check(data, h.sourceLimits)
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*picture.LimitError); ok {
+		e := newHTTPError(http.StatusRequestEntityTooLarge, "source image is too large:"+err.Error())
+		e.negative = true
+		h.addNegative(sourceURL, e)
+		return e
+	}
+
+	return h.decodeError(sourceURL, err)
+}
+
+// checkOutputSize returns error if the size of resized image exceeds the limits
+func (h *Handler) checkOutputSize(width, height uint) error {
+
+	if (h.maxOutputWidth > 0 && width > h.maxOutputWidth) || (h.maxOutputHeight > 0 && height > h.maxOutputHeight) {
+		return newHTTPError(http.StatusUnprocessableEntity, "size of resized image exceeds the limit "+
+			strconv.FormatUint(uint64(h.maxOutputWidth), 10)+"x"+strconv.FormatUint(uint64(h.maxOutputHeight), 10))
+	}
+
+	return nil
+}
+
+// checkResizedSize returns error if the resized source image exceeds the limits,
+// zero width or height is calculated by the aspect ratio of the source image
+func (h *Handler) checkResizedSize(data []byte, width, height uint) error {
+
+	if h.maxOutputWidth == 0 && h.maxOutputHeight == 0 {
+		return nil
+	}
+
+	width, height, err := picture.OutputSize(data, width, height)
+	if err != nil {
+		return newHTTPError(500, "internal server error:"+err.Error())
+	}
+
+	return h.checkOutputSize(width, height)
+}
+
+// cacheControl returns value of Cache-Control header,
+// the images which the resource forbids to store in shared caches aren't stored by clients as well
+func (h *Handler) cacheControl(maxAge time.Duration, noStore bool) string {
+
+	if noStore {
+		return "no-store"
+	}
+
+	// round up, so the image cached just now has max-age equal to the lifetime
+	value := "max-age=" + strconv.FormatInt(int64((maxAge+time.Second-1)/time.Second), 10)
+
+	if h.staleWhileRevalidate > 0 {
+		value += ", stale-while-revalidate=" + strconv.FormatInt(int64(h.staleWhileRevalidate/time.Second), 10)
+	}
+
+	if h.staleIfError > 0 {
+		value += ", stale-if-error=" + strconv.FormatInt(int64(h.staleIfError/time.Second), 10)
+	}
+
+	return value
+}
+
+// result - image with meta information
+type result struct {
+	data        []byte
+	meta        cache.Meta
+	notModified bool // the source image isn't modified on resource since it was cached
+}
+
+// hasValidators returns true if the source image may be revalidated on resource
+func hasValidators(meta cache.Meta) bool {
+	return meta.ETag != "" || meta.LastModified != ""
+}
+
+// load source image, resize it and put result to cache.
+// The validators of expired image are used to revalidate the source image on resource.
+func (h *Handler) load(ctx context.Context, cacheKey string, reqSpec spec.Spec, tags []string, validators cache.Meta) (result, error) {
+
+	src, err := h.loadSource(ctx, reqSpec.URL, reqSpec.RawURL, validators)
+	if err != nil {
+		return result{}, err
+	}
+
+	if src.notModified {
+		// the expired images of the source are extended
+		if data, meta, ok := h.cache.Lookup(cacheKey); ok {
+			return result{data: data, meta: meta}, nil
+		}
+
+		if src.data == nil {
+			// the image has been removed from cache, so the source image is needed
+			if src, err = h.loadSource(ctx, reqSpec.URL, reqSpec.RawURL, cache.Meta{}); err != nil {
+				return result{}, err
+			}
 		}
+	}
+
+	atomic.AddInt64(&h.stats.Loads, 1)
+
+	if err := h.checkSource(reqSpec.URL, src.data); err != nil {
+		return result{}, err
+	}
+
+	if err := h.checkResizedSize(src.data, reqSpec.Width, reqSpec.Height); err != nil {
+		return result{}, err
+	}
+
+	buf := buffer.New(int(atomic.LoadInt64(&h.maxFileSize)))
+	out := bytes.NewBuffer(nil)
+	wr := io.MultiWriter(buf, out)
+	if err := picture.Resize(wr, bytes.NewReader(src.data), reqSpec.Width, reqSpec.Height); err != nil {
+		return result{}, h.decodeError(reqSpec.URL, err)
+	}
+
+	meta := src.meta
+	meta.Tags = addTags(src.meta.Tags, tags)
 
+	if !meta.NoStore {
 		if data, ok := buf.Get(); ok {
-			h.cache.Add(cacheKey, data)
+			h.cache.Set(cacheKey, data, meta)
 		}
+
+		h.store(cacheKey, out.Bytes())
 	}
 
-	w.Header().Add("Content-type", "image/jpeg")
+	return result{data: out.Bytes(), meta: meta}, nil
+}
+
+// loadSource returns source image from cache or from resource, the canonical URL is the key of the image
+// and the raw URL is loaded from resource. The expired source image is revalidated on resource
+// by its validators or by the passed ones, if it isn't modified, the source image and its resized images are extended.
+func (h *Handler) loadSource(ctx context.Context, reqURL, rawURL string, validators cache.Meta) (result, error) {
+
+	sourceKey := cache.NewKey(reqURL)
+
+	if err := h.checkNegative(reqURL); err != nil {
+		return result{}, err
+	}
+
+	if data, meta, ok := h.sourceCache.Lookup(sourceKey); ok {
+		log.Println("send from source cache")
+		atomic.AddInt64(&h.stats.SourceCacheHits, 1)
+		return result{data: data, meta: meta}, nil
+	}
+
+	// the key is prefixed to not mix with keys of resized images
+	val, _, err := h.flight.DoContext(ctx, "source:"+sourceKey, func(ctx context.Context) (interface{}, error) {
+		if data, meta, ok := h.sourceCache.Lookup(sourceKey); ok {
+			return result{data: data, meta: meta}, nil
+		}
+
+		var stale result
+		if data, meta, _, ok := h.sourceCache.LookupStale(sourceKey); ok && hasValidators(meta) {
+			stale = result{data: data, meta: meta}
+			validators = meta
+		}
+
+		src, err := h.fetch(ctx, rawURL, validators)
+		if err != nil {
+			h.addNegative(reqURL, err)
+			return nil, err
+		}
+
+		src.meta.Source = reqURL
+
+		if src.notModified {
+			log.Println("source is not modified on resource")
+			atomic.AddInt64(&h.stats.SourceRevalidations, 1)
+
+			h.extend(reqURL, src.meta.Lifetime)
+
+			stale.notModified = true
+			return stale, nil
+		}
+
+		if !src.meta.NoStore {
+			h.sourceCache.Set(sourceKey, src.data, src.meta)
+		}
+
+		return src, nil
+	})
+	if err != nil {
+		return result{}, err
+	}
+
+	return val.(result), nil
+}
+
+// extend lifetime of the source image and its resized images which are not modified on resource
+func (h *Handler) extend(sourceURL string, lifetime time.Duration) {
+
+	h.sourceCache.Extend(cache.NewKey(sourceURL), lifetime)
+
+	h.cache.Range(func(e cache.Entry) bool {
+		if e.Meta.Source == sourceURL {
+			h.cache.Extend(e.Key, lifetime)
+		}
+		return true
+	})
+}
+
+// forward sends the request to the peer owning the key, returns false if the request must be served locally
+func (h *Handler) forward(w http.ResponseWriter, req *http.Request, key string) bool {
+
+	pool, peer, ok := h.owner(req, key)
+	if !ok {
+		return false
+	}
+
+	log.Println("send from peer", peer)
+
+	res, err := pool.Forward(peer, req)
+	if err != nil {
+		log.Println("ERROR: failed to get image from peer:", err)
+		return false
+	}
+
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.Println("ERROR:", err)
+		}
+	}()
+
+	for name, values := range res.Header {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+
+	w.WriteHeader(res.StatusCode)
+	if _, err := io.Copy(w, res.Body); err != nil {
+		log.Println("ERROR: failed to send image from peer:", err)
+	}
+
+	return true
+}
+
+// owner returns the peer owning the key, ok is false if the request must be handled locally
+func (h *Handler) owner(req *http.Request, key string) (pool *peers.Pool, peer string, ok bool) {
+
+	h.peersMu.RLock()
+	pool = h.peers
+	h.peersMu.RUnlock()
+
+	if pool == nil || peers.IsForwarded(req) {
+		return nil, "", false
+	}
+
+	peer, ok = pool.Pick(key)
+	return pool, peer, ok
 }
@@ -2,6 +2,7 @@ package images
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"image"
@@ -13,15 +14,21 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/khevse/image-resizer/service/images/internal/fetch"
 	"github.com/stretchr/testify/require"
 )
 
 func TestResize(t *testing.T) {
 
 	mux := http.NewServeMux()
-	mux.Handle("/", New().Mux())
+	mux.Handle("/", helperNew().Mux())
 	mux.HandleFunc("/source", func(w http.ResponseWriter, req *http.Request) {
 
 		buf := helperNewImage(t, 1000, 1000)
@@ -50,6 +57,224 @@ func TestResize(t *testing.T) {
 	}
 }
 
+func TestResizeCluster(t *testing.T) {
+
+	var sourceRequests int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+
+		buf := helperNewImage(t, 1000, 1000)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	handlers := make([]*Handler, 3)
+	servers := make([]*httptest.Server, len(handlers))
+	addrs := make([]string, len(handlers))
+	for i := range handlers {
+		handlers[i] = helperNew()
+		defer handlers[i].Close()
+
+		servers[i] = httptest.NewServer(handlers[i].Mux())
+		defer servers[i].Close()
+
+		addrs[i] = servers[i].URL
+	}
+
+	for i := range handlers {
+		require.NoError(t, handlers[i].SetPeers(addrs[i], addrs...))
+	}
+
+	require.Error(t, handlers[0].SetPeers("", addrs...))
+	require.Error(t, handlers[0].SetPeers("http://127.0.0.1:1", addrs...))
+
+	for size := 10; size < 20; size++ {
+		sourceBefore := atomic.LoadInt32(&sourceRequests)
+
+		for _, svr := range servers {
+			u, err := url.Parse(svr.URL + "/resize")
+			require.NoError(t, err)
+			helperSetQuery(u,
+				"url", source.URL+"/"+strconv.Itoa(size),
+				"width", strconv.Itoa(size),
+				"height", strconv.Itoa(size))
+
+			res, err := http.Get(u.String())
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, "image/jpeg", res.Header.Get("Content-type"))
+
+			img, err := jpeg.Decode(res.Body)
+			require.NoError(t, err)
+			require.NoError(t, res.Body.Close())
+			require.Equal(t, image.Rect(0, 0, size, size), img.Bounds())
+		}
+
+		// the image is resized only once by the owner of the key
+		require.Equal(t, sourceBefore+1, atomic.LoadInt32(&sourceRequests), size)
+	}
+
+	{
+		// test: the sizes of batch are resized by the owners of keys
+		u, err := url.Parse(servers[0].URL + "/resize/batch")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL+"/batch", "sizes", "30x30,31x31,32x32,33x33,34x34,35x35")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		sourceBefore := atomic.LoadInt32(&sourceRequests)
+
+		for size := 30; size < 36; size++ {
+			for _, svr := range servers {
+				u, err := url.Parse(svr.URL + "/resize")
+				require.NoError(t, err)
+				helperSetQuery(u,
+					"url", source.URL+"/batch",
+					"width", strconv.Itoa(size),
+					"height", strconv.Itoa(size))
+
+				res, err := http.Get(u.String())
+				require.NoError(t, err)
+				require.NoError(t, res.Body.Close())
+				require.Equal(t, http.StatusOK, res.StatusCode)
+			}
+		}
+
+		require.Equal(t, sourceBefore, atomic.LoadInt32(&sourceRequests))
+	}
+}
+
+func TestResizeCoalescing(t *testing.T) {
+
+	const Requests = 20
+
+	var sourceRequests int32
+	release := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+		<-release
+
+		buf := helperNewImage(t, 1000, 1000)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	h := helperNew()
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", source.URL, "width", "20", "height", "20")
+
+	results := make(chan string, Requests)
+	for i := 0; i < Requests; i++ {
+		go func() {
+			res, err := http.Get(u.String())
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+
+			data, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+
+			results <- helperMD5(t, data)
+		}()
+	}
+
+	for h.Stats().Requests < Requests {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Second / 10) // wait until all requests join the running one
+	close(release)
+
+	for i := 0; i < Requests; i++ {
+		require.Equal(t, "4180c34b5cba2a4c3a15a623fd177099", <-results)
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&sourceRequests))
+	require.Equal(t,
+		Stats{
+			Requests:  Requests,
+			Loads:     1,
+			Coalesced: Requests - 1,
+
+			SourceLoads: 1,
+		},
+		h.Stats())
+
+	{
+		// test: stats handler
+		res, err := http.Get(svr.URL + "/stats")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "application/json", res.Header.Get("Content-type"))
+		require.JSONEq(t,
+			`{"requests":20,"cache_hits":0,"loads":1,"coalesced":19,"stale_hits":0,"stale_errors":0,"negative_hits":0,`+
+				`"source_cache_hits":0,"source_loads":1,"source_revalidations":0,"breaker_rejects":0,"sink_writes":0,"sink_errors":0}`,
+			helperGetStringFromBody(t, res))
+	}
+}
+
+func TestResizeSourceCache(t *testing.T) {
+
+	var sourceRequests int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+
+		buf := helperNewImage(t, 1000, 1000)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	h := helperNew()
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+
+	for i, sourceURL := range []string{
+		source.URL + "/img?a=1&b=2",
+		source.URL + "/img?b=2&a=1",
+		strings.ToUpper(source.URL[:4]) + source.URL[4:] + "/img?a=1&b=2#top",
+	} {
+		size := strconv.Itoa(10 + i)
+		helperSetQuery(u, "url", sourceURL, "width", size, "height", size)
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&sourceRequests))
+	require.Equal(t,
+		Stats{
+			Requests: 3,
+			Loads:    3,
+
+			SourceCacheHits: 2,
+			SourceLoads:     1,
+		},
+		h.Stats())
+}
+
 func testResizeInvalidHeight(t *testing.T, u *url.URL) {
 
 	{
@@ -116,6 +341,144 @@ func testResizeInvalidWith(t *testing.T, u *url.URL) {
 	}
 }
 
+func TestResizeRawURL(t *testing.T) {
+
+	var (
+		queries []string
+		mu      sync.Mutex
+	)
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		queries = append(queries, req.URL.RawQuery)
+		mu.Unlock()
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	h := helperNew()
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	// the canonical URL is used only for keys, the resource gets the URL sent by client
+	for _, query := range []string{"a=1;b=2", "v123", "z=1&a=2"} {
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL+"/a.jpg?"+query, "width", "10", "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusOK, res.StatusCode, query)
+	}
+
+	require.Equal(t, []string{"a=1;b=2", "v123", "z=1&a=2"}, queries)
+}
+
+func TestResizeDeniedResource(t *testing.T) {
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Fatal("the resource must not be requested")
+	}))
+	defer source.Close()
+
+	h := New()
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	for _, resource := range []string{source.URL, "http://169.254.169.254/latest/meta-data/"} {
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", resource, "width", "10", "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, res.StatusCode, resource)
+		require.Contains(t, helperGetStringFromBody(t, res), "is denied", resource)
+	}
+}
+
+func TestResizeCanceled(t *testing.T) {
+
+	canceled := make(chan struct{})
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+		close(canceled)
+	}))
+	defer source.Close()
+
+	h := helperNew()
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", source.URL, "width", "10", "height", "10")
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = http.DefaultClient.Do(req.WithContext(ctx))
+	require.Error(t, err)
+
+	// the request to resource is canceled with the client request
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request to resource isn't canceled")
+	}
+}
+
+func TestResizeRetries(t *testing.T) {
+
+	var sourceRequests int32
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&sourceRequests, 1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	cfg := helperConfig()
+	cfg.FetchRetries = 2
+	cfg.FetchRetryDelay = time.Millisecond
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", source.URL, "width", "10", "height", "10")
+
+	res, err := http.Get(u.String())
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, int32(3), atomic.LoadInt32(&sourceRequests))
+}
+
 func testResizeInvalidUrl(t *testing.T, u *url.URL) {
 
 	{
@@ -134,10 +497,22 @@ func testResizeInvalidUrl(t *testing.T, u *url.URL) {
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, res.StatusCode)
 		require.Equal(t,
-			`failed to send request:Get -: unsupported protocol scheme ""`+"\n",
+			`invalid resource URL:unsupported scheme ""`+"\n",
 			helperGetStringFromBody(t, res))
 	}
 
+	for sourceURL, exp := range map[string]string{
+		"ftp://example.com/a.jpg": `invalid resource URL:unsupported scheme "ftp"`,
+		"file:///a.jpg":           `invalid resource URL:unsupported scheme "file"`, // the file source is disabled
+		"http:///a.jpg":           `invalid resource URL:no host`,
+	} {
+		helperSetQuery(u, "url", sourceURL, "width", "20", "height", "20")
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, res.StatusCode, sourceURL)
+		require.Equal(t, exp+"\n", helperGetStringFromBody(t, res), sourceURL)
+	}
+
 	{
 		helperSetQuery(u, "url", "https://google.com", "width", "20", "height", "20")
 		res, err := http.Get(u.String())
@@ -238,3 +613,18 @@ func helperMD5(t *testing.T, src []byte) string {
 
 	return string(buf)
 }
+
+// helperConfig returns default config which allows resources on loopback addresses of test servers,
+// the failed requests to resources are not retried
+func helperConfig() Config {
+
+	cfg := DefaultConfig()
+	cfg.AllowNetworks = fetch.Loopback
+	cfg.FetchRetries = 0 // the tests count requests to resources
+
+	return cfg
+}
+
+func helperNew() *Handler {
+	return NewWithConfig(helperConfig())
+}
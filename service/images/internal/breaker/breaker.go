@@ -0,0 +1,216 @@
+package breaker
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+)
+
+// State of circuit breaker
+type State int
+
+// States of circuit breaker
+const (
+	Closed   State = iota // requests are allowed
+	Open                  // requests are rejected
+	HalfOpen              // one request is allowed to check the host
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Result of the allowed request
+type Result int
+
+// Results of requests
+const (
+	Success  Result = iota // the host is healthy, the breaker is closed
+	Failure                // the host is failing
+	Canceled               // the request is canceled, the result doesn't change the state of host
+)
+
+// Config of breakers
+type Config struct {
+	Failures      int           // number of consecutive failures which opens the breaker, breakers are disabled if it's zero
+	OpenTimeout   time.Duration // time while the breaker is open, then one request is allowed to check the host
+	MaxConcurrent int           // max number of concurrent requests to one host, no limit if it's zero
+	MaxHosts      int           // max number of hosts with state, the idle hosts are forgotten when it's reached, the default value is used if it's zero
+}
+
+const defaultMaxHosts = 10000
+
+// Error - the request is rejected
+type Error struct {
+	RetryAfter time.Duration // time after which the request may be repeated
+	msg        string
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// HostStats - state of the host
+type HostStats struct {
+	State    string `json:"state"`
+	Failures int    `json:"failures"` // number of consecutive failures
+	InFlight int    `json:"in_flight"`
+}
+
+type host struct {
+	state    State
+	failures int
+	openedAt time.Time
+	inFlight int
+}
+
+// Set of breakers and limits of concurrent requests by hosts
+type Set struct {
+	cfg   Config
+	clock clock.Clock
+	hosts map[string]*host
+	mu    sync.Mutex
+}
+
+// New set of breakers
+func New(cfg Config, clk clock.Clock) *Set {
+	return &Set{
+		cfg:   cfg,
+		clock: clk,
+		hosts: make(map[string]*host),
+	}
+}
+
+// Acquire allows request to the host or returns *Error,
+// done must be called with result of the allowed request.
+func (s *Set) Acquire(name string) (done func(res Result), err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.hosts[name]
+	if !ok {
+		s.evict()
+		h = &host{}
+		s.hosts[name] = h
+	}
+
+	if h.state == Open {
+		if retryAfter := h.openedAt.Add(s.cfg.OpenTimeout).Sub(s.clock.Now()); retryAfter > 0 {
+			return nil, &Error{
+				RetryAfter: retryAfter,
+				msg:        "circuit breaker of host " + name + " is open",
+			}
+		}
+
+		h.state = HalfOpen
+	} else if h.state == HalfOpen {
+		// the host is being checked by other request
+		return nil, &Error{
+			RetryAfter: time.Second,
+			msg:        "circuit breaker of host " + name + " is half-open",
+		}
+	}
+
+	if s.cfg.MaxConcurrent > 0 && h.inFlight >= s.cfg.MaxConcurrent {
+		return nil, &Error{
+			RetryAfter: time.Second,
+			msg:        "too many concurrent requests to host " + name + ", the limit is " + strconv.Itoa(s.cfg.MaxConcurrent),
+		}
+	}
+
+	h.inFlight++
+
+	var once sync.Once
+	return func(res Result) {
+		once.Do(func() { s.done(name, h, res) })
+	}, nil
+}
+
+func (s *Set) done(name string, h *host, res Result) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h.inFlight--
+
+	switch res {
+	case Success:
+		h.state = Closed
+		h.failures = 0
+	case Canceled:
+		if h.state == HalfOpen {
+			h.state = Open // the check of host isn't finished, so the next request checks it
+		}
+	default:
+		h.failures++
+		if h.state == HalfOpen || (s.cfg.Failures > 0 && h.failures >= s.cfg.Failures) {
+			h.state = Open
+			h.openedAt = s.clock.Now()
+		}
+		return
+	}
+
+	if h.state == Closed && h.failures == 0 && h.inFlight == 0 {
+		delete(s.hosts, name) // the healthy host without requests has no state
+	}
+}
+
+// evict removes idle hosts if the max number of hosts is reached, the open breakers are kept
+// while they can, so the failing hosts can't be reset by requests to many other hosts. s.mu must be locked.
+func (s *Set) evict() {
+
+	maxHosts := s.cfg.MaxHosts
+	if maxHosts == 0 {
+		maxHosts = defaultMaxHosts
+	}
+
+	if len(s.hosts) < maxHosts {
+		return
+	}
+
+	now := s.clock.Now()
+	for name, h := range s.hosts {
+		if h.inFlight == 0 && (h.state != Open || !now.Before(h.openedAt.Add(s.cfg.OpenTimeout))) {
+			delete(s.hosts, name)
+		}
+	}
+
+	if len(s.hosts) < maxHosts {
+		return
+	}
+
+	for name, h := range s.hosts {
+		if h.inFlight == 0 {
+			delete(s.hosts, name)
+		}
+	}
+}
+
+// Stats returns states of hosts
+func (s *Set) Stats() map[string]HostStats {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]HostStats, len(s.hosts))
+	for name, h := range s.hosts {
+		stats[name] = HostStats{
+			State:    h.state.String(),
+			Failures: h.failures,
+			InFlight: h.inFlight,
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,163 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := New(Config{Failures: 2, OpenTimeout: time.Minute}, clk)
+
+	request := func(name string, res Result) error {
+		t.Helper()
+
+		done, err := s.Acquire(name)
+		if err != nil {
+			return err
+		}
+		done(res)
+
+		return nil
+	}
+
+	{
+		// test: consecutive failures open the breaker
+		require.NoError(t, request("a.com", Failure))
+		require.NoError(t, request("a.com", Success))
+		require.NoError(t, request("a.com", Failure))
+		require.Equal(t, HostStats{State: "closed", Failures: 1}, s.Stats()["a.com"])
+
+		require.NoError(t, request("a.com", Failure))
+		require.Equal(t, HostStats{State: "open", Failures: 2}, s.Stats()["a.com"])
+
+		err := request("a.com", Success)
+		require.Error(t, err)
+		require.Equal(t, time.Minute, err.(*Error).RetryAfter)
+		require.Equal(t, "circuit breaker of host a.com is open", err.Error())
+
+		// other hosts are not affected
+		require.NoError(t, request("b.com", Success))
+
+		clk.Advance(40 * time.Second)
+		err = request("a.com", Success)
+		require.Error(t, err)
+		require.Equal(t, 20*time.Second, err.(*Error).RetryAfter)
+	}
+
+	{
+		// test: failed check opens the breaker again
+		clk.Advance(20 * time.Second)
+
+		done, err := s.Acquire("a.com")
+		require.NoError(t, err)
+		require.Equal(t, HostStats{State: "half-open", Failures: 2, InFlight: 1}, s.Stats()["a.com"])
+
+		// only one request checks the host
+		_, err = s.Acquire("a.com")
+		require.Error(t, err)
+
+		done(Failure)
+		require.Equal(t, HostStats{State: "open", Failures: 3}, s.Stats()["a.com"])
+		require.Error(t, request("a.com", Success))
+	}
+
+	{
+		// test: canceled check doesn't close the breaker, the next request checks the host
+		clk.Advance(time.Minute)
+
+		done, err := s.Acquire("a.com")
+		require.NoError(t, err)
+		done(Canceled)
+		require.Equal(t, HostStats{State: "open", Failures: 3}, s.Stats()["a.com"])
+
+		done, err = s.Acquire("a.com")
+		require.NoError(t, err)
+		require.Equal(t, HostStats{State: "half-open", Failures: 3, InFlight: 1}, s.Stats()["a.com"])
+		done(Failure)
+	}
+
+	{
+		// test: successful check closes the breaker
+		clk.Advance(time.Minute)
+
+		require.NoError(t, request("a.com", Success))
+		require.NoError(t, request("a.com", Failure))
+		require.Equal(t, HostStats{State: "closed", Failures: 1}, s.Stats()["a.com"])
+	}
+
+	require.NotContains(t, s.Stats(), "b.com")
+}
+
+func TestMaxHosts(t *testing.T) {
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := New(Config{Failures: 1, OpenTimeout: time.Minute, MaxHosts: 3}, clk)
+
+	fail := func(name string) {
+		t.Helper()
+
+		done, err := s.Acquire(name)
+		require.NoError(t, err)
+		done(Failure)
+	}
+
+	fail("a.com")
+	clk.Advance(time.Minute)
+	fail("b.com")
+
+	busy, err := s.Acquire("c.com")
+	require.NoError(t, err)
+
+	// test: the idle hosts are forgotten, the open breakers and the hosts with requests are kept
+	fail("d.com")
+	require.Len(t, s.Stats(), 3)
+	require.NotContains(t, s.Stats(), "a.com")
+	require.Equal(t, "open", s.Stats()["b.com"].State)
+
+	// test: the open breakers are forgotten too if there are no other idle hosts
+	fail("e.com")
+	require.Len(t, s.Stats(), 2)
+	require.Contains(t, s.Stats(), "c.com")
+	require.Contains(t, s.Stats(), "e.com")
+
+	busy(Success)
+}
+
+func TestMaxConcurrent(t *testing.T) {
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := New(Config{MaxConcurrent: 2}, clk)
+
+	done1, err := s.Acquire("a.com")
+	require.NoError(t, err)
+	done2, err := s.Acquire("a.com")
+	require.NoError(t, err)
+
+	_, err = s.Acquire("a.com")
+	require.Error(t, err)
+	require.Equal(t, "too many concurrent requests to host a.com, the limit is 2", err.Error())
+
+	_, err = s.Acquire("b.com")
+	require.NoError(t, err)
+
+	done1(Success)
+	done1(Success) // the second call is ignored
+	require.Equal(t, 1, s.Stats()["a.com"].InFlight)
+
+	_, err = s.Acquire("a.com")
+	require.NoError(t, err)
+
+	done2(Failure)
+
+	// the breakers are disabled
+	for i := 0; i < 10; i++ {
+		done, err := s.Acquire("c.com")
+		require.NoError(t, err)
+		done(Failure)
+	}
+}
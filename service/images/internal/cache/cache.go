@@ -1,35 +1,97 @@
 package cache
 
 import (
+	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
 )
 
+var errUnknownSnapshot = errors.New("unknown version of cache snapshot")
+
 type cacheItem struct {
 	Key     string
 	Data    []byte
 	Expired time.Time
+	Meta    Meta
+}
+
+// Meta - additional information about cache item
+type Meta struct {
+	Source  string   // URL of source image
+	Tags    []string // tags (surrogate keys) for invalidation
+	Status  int      // HTTP status code of cached error
+	Failure bool     // cached error is caused by failure of resource (network error, timeout or 5xx response)
+
+	SourceName string // name of source of origin which served the source image, it's empty for origins without fallback
+
+	Lifetime time.Duration // lifetime of the item, the cache lifetime is used if it's zero
+	NoStore  bool          // the resource forbids storing of the image in shared caches (no-store, no-cache or private)
+
+	// validators of source image on resource
+	ETag         string
+	LastModified string
+
+	Modified time.Time // time of last modification of source image
+}
+
+// Entry - description of cache item
+type Entry struct {
+	Key     string
+	Size    int
+	Expired time.Time
+	Meta    Meta
+}
+
+// Stats - cache counters
+type Stats struct {
+	Entries     int   `json:"entries"`
+	Bytes       int64 `json:"bytes"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Evictions   int64 `json:"evictions"`   // number of items removed because of cache overflow
+	Expirations int64 `json:"expirations"` // number of items removed because of lifetime
 }
 
 // Cache - internal cache
 type Cache struct {
 	maxFileSize    int64
 	lifetime       time.Duration
+	grace          time.Duration // time of keeping expired items
 	cleanerTimeout time.Duration
+	clock          clock.Clock
+	stopCleaner    func()
 	payload        []*cacheItem
+	tags           map[string]map[string]struct{} // tag -> keys
+
+	hits        int64
+	misses      int64
+	evictions   int64
+	expirations int64
 
 	closed int32
 	mu     sync.Mutex
 }
 
-// New return new cache object
+// New return new cache object, the cache is disabled if maxItems <= 0
 func New(maxFileSize int64, maxItems int, lifetime, cleanerTimeout time.Duration) *Cache {
+	return NewWithClock(maxFileSize, maxItems, lifetime, cleanerTimeout, clock.Real{})
+}
+
+// NewWithClock return new cache object which uses the clock for expiration and the autocleaner
+func NewWithClock(maxFileSize int64, maxItems int, lifetime, cleanerTimeout time.Duration, clk clock.Clock) *Cache {
+
+	if maxItems < 0 {
+		maxItems = 0
+	}
 
 	c := &Cache{
 		maxFileSize:    maxFileSize,
 		lifetime:       lifetime,
 		cleanerTimeout: cleanerTimeout,
+		clock:          clk,
 		payload:        make([]*cacheItem, 0, maxItems),
 	}
 
@@ -37,19 +99,34 @@ func New(maxFileSize int64, maxItems int, lifetime, cleanerTimeout time.Duration
 	return c
 }
 
+// SetGrace sets time of keeping expired items, they are available only by LookupStale
+func (c *Cache) SetGrace(grace time.Duration) {
+	atomic.StoreInt64((*int64)(&c.grace), int64(grace))
+}
+
 // Add new value to cache
 func (c *Cache) Add(key string, data []byte) {
+	c.Set(key, data, Meta{})
+}
+
+// Set adds new value with meta information to cache, the previous value with the same key is replaced
+func (c *Cache) Set(key string, data []byte, meta Meta) {
 
 	if datalen := len(data); datalen == 0 || atomic.LoadInt64(&c.maxFileSize) < int64(datalen) {
 		return // ignore file
 	}
 
 	lifetime := time.Duration(atomic.LoadInt64((*int64)(&c.lifetime)))
+	if meta.Lifetime > 0 {
+		lifetime = meta.Lifetime
+	}
+
+	meta.Tags = append([]string(nil), meta.Tags...)
 
 	newItem := &cacheItem{
-		Key:     key,
-		Data:    make([]byte, len(data)),
-		Expired: time.Now().Add(lifetime),
+		Key:  key,
+		Data: make([]byte, len(data)),
+		Meta: meta,
 	}
 
 	copy(newItem.Data, data)
@@ -57,25 +134,120 @@ func (c *Cache) Add(key string, data []byte) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if (cap(c.payload) - len(c.payload)) > 0 {
+	if cap(c.payload) == 0 {
+		return // cache is disabled
+	}
+
+	newItem.Expired = c.now().Add(lifetime)
+
+	if i := c.indexOf(key); i >= 0 {
+		c.untag(c.payload[i])
+		c.payload[i] = newItem
+	} else if (cap(c.payload) - len(c.payload)) > 0 {
 		c.payload = append(c.payload, newItem)
 	} else {
 		lastIndex := len(c.payload) - 1
+		c.untag(c.payload[lastIndex])
 		c.payload[lastIndex] = newItem
+		atomic.AddInt64(&c.evictions, 1)
 	}
+
+	c.tag(newItem)
+}
+
+// AddTags adds tags to existing value, the tags aren't added beyond max number of tags of the value (no limit if max <= 0)
+func (c *Cache) AddTags(key string, max int, tags ...string) bool {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := c.indexOf(key)
+	if i < 0 {
+		return false
+	}
+
+	item := c.payload[i]
+	c.untag(item)
+
+	newTags := make([]string, 0, len(item.Meta.Tags)+len(tags))
+	newTags = append(newTags, item.Meta.Tags...)
+	for _, tag := range tags {
+		if max > 0 && len(newTags) >= max {
+			break
+		}
+		if !contains(newTags, tag) {
+			newTags = append(newTags, tag)
+		}
+	}
+	item.Meta.Tags = newTags
+
+	c.tag(item)
+
+	return true
+}
+
+// Extend sets new lifetime of existing value, the value may be expired, but not older than the grace time.
+// The lifetime of value is used if the lifetime is zero.
+func (c *Cache) Extend(key string, lifetime time.Duration) bool {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	i := c.indexOf(key)
+	if i < 0 || c.isOutdated(c.payload[i], now) {
+		return false
+	}
+
+	item := c.payload[i]
+	if lifetime > 0 {
+		item.Meta.Lifetime = lifetime
+	} else if item.Meta.Lifetime > 0 {
+		lifetime = item.Meta.Lifetime
+	} else {
+		lifetime = time.Duration(atomic.LoadInt64((*int64)(&c.lifetime)))
+	}
+
+	item.Expired = now.Add(lifetime)
+
+	return true
 }
 
 // Get return file if exist in cache
 func (c *Cache) Get(key string) (data []byte, ok bool) {
+	data, _, ok = c.Lookup(key)
+	return data, ok
+}
+
+// Lookup returns file with meta information if exist in cache
+func (c *Cache) Lookup(key string) (data []byte, meta Meta, ok bool) {
+	data, meta, _, ok = c.lookup(key, false)
+	return data, meta, ok
+}
+
+// LookupStale returns file with meta information and expiration time if exist in cache,
+// the file may be expired, but not older than the grace time.
+func (c *Cache) LookupStale(key string) (data []byte, meta Meta, expired time.Time, ok bool) {
+	return c.lookup(key, true)
+}
+
+func (c *Cache) lookup(key string, stale bool) (data []byte, meta Meta, expired time.Time, ok bool) {
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	now := c.now()
+
 	for i, item := range c.payload {
 		if item.Key != key {
 			continue
 		}
 
+		if c.isOutdated(item, now) || (!stale && !now.Before(item.Expired)) {
+			break // it will be removed by the cleaner
+		}
+
 		data = make([]byte, len(item.Data))
 		copy(data, item.Data)
 		ok = true
@@ -86,49 +258,220 @@ func (c *Cache) Get(key string) (data []byte, ok bool) {
 			c.payload[0] = item
 		}
 
-		return data, ok
+		meta = item.Meta
+		meta.Tags = append([]string(nil), item.Meta.Tags...)
+
+		atomic.AddInt64(&c.hits, 1)
+		return data, meta, item.Expired, ok
 	}
 
-	return nil, false
+	atomic.AddInt64(&c.misses, 1)
+	return nil, Meta{}, time.Time{}, false
+}
+
+// Now returns current time of the cache clock
+func (c *Cache) Now() time.Time {
+	return c.now()
+}
+
+// Delete removes value from cache
+func (c *Cache) Delete(key string) bool {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := c.indexOf(key)
+	if i < 0 {
+		return false
+	}
+
+	c.remove(i)
+	return true
+}
+
+// DeleteTag removes all values with the tag, returns number of removed values
+func (c *Cache) DeleteTag(tag string) int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var count int
+	for key := range c.tags[tag] {
+		if i := c.indexOf(key); i >= 0 {
+			c.remove(i)
+			count++
+		}
+	}
+
+	return count
+}
+
+// Clear removes all values from cache, returns number of removed values
+func (c *Cache) Clear() int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := len(c.payload)
+	for i := range c.payload {
+		c.payload[i] = nil
+	}
+	c.payload = c.payload[:0]
+	c.tags = nil
+
+	return count
+}
+
+// Range calls fn for each cache item until fn returns false,
+// fn is called without lock, so it may change the cache.
+func (c *Cache) Range(fn func(Entry) bool) {
+
+	c.mu.Lock()
+	entries := make([]Entry, 0, len(c.payload))
+	for _, item := range c.payload {
+		entries = append(entries, Entry{
+			Key:     item.Key,
+			Size:    len(item.Data),
+			Expired: item.Expired,
+			Meta:    item.Meta,
+		})
+	}
+	c.mu.Unlock()
+
+	for _, entry := range entries {
+		if !fn(entry) {
+			return
+		}
+	}
+}
+
+// Stats returns cache counters
+func (c *Cache) Stats() Stats {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := Stats{
+		Entries:     len(c.payload),
+		Hits:        atomic.LoadInt64(&c.hits),
+		Misses:      atomic.LoadInt64(&c.misses),
+		Evictions:   atomic.LoadInt64(&c.evictions),
+		Expirations: atomic.LoadInt64(&c.expirations),
+	}
+
+	for _, item := range c.payload {
+		stats.Bytes += int64(len(item.Data))
+	}
+
+	return stats
 }
 
 // Close cache (stop autoclean)
 func (c *Cache) Close() error {
-	atomic.StoreInt32(&c.closed, 1)
+
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.stopCleaner()
+	}
+
 	return nil
 }
 
 func (c *Cache) runAutoCleaner() {
-
 	timeout := time.Duration(atomic.LoadInt64((*int64)(&c.cleanerTimeout)))
+	c.stopCleaner = c.clock.Every(timeout, c.clean)
+}
+
+// clean removes expired items
+func (c *Cache) clean() {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	var i int
+	for i < len(c.payload) {
+		item := c.payload[i]
+		if !c.isOutdated(item, now) {
+			i++
+			continue
+		}
+
+		c.remove(i)
+		atomic.AddInt64(&c.expirations, 1)
+	}
+}
+
+func (c *Cache) now() time.Time {
+	return c.clock.Now()
+}
+
+// isOutdated returns true if the item is expired and its grace time is over
+func (c *Cache) isOutdated(item *cacheItem, now time.Time) bool {
+	grace := time.Duration(atomic.LoadInt64((*int64)(&c.grace)))
+	return !now.Before(item.Expired.Add(grace))
+}
+
+// indexOf returns index of item with the key or -1, must be called under lock
+func (c *Cache) indexOf(key string) int {
+
+	for i, item := range c.payload {
+		if item.Key == key {
+			return i
+		}
+	}
 
-	go func() {
-		for {
-			if atomic.LoadInt32(&c.closed) > 0 {
-				return
-			}
+	return -1
+}
 
-			time.Sleep(timeout)
+// remove item by index, must be called under lock
+func (c *Cache) remove(i int) {
 
-			now := time.Now()
+	c.untag(c.payload[i])
 
-			func() {
+	copy(c.payload[i:], c.payload[i+1:])
+	c.payload[len(c.payload)-1] = nil
+	c.payload = c.payload[:len(c.payload)-1]
+}
 
-				c.mu.Lock()
-				defer c.mu.Unlock()
+// tag adds item to index of tags, must be called under lock
+func (c *Cache) tag(item *cacheItem) {
 
-				var i int
-				for i < len(c.payload) {
-					item := c.payload[i]
-					if now.Before(item.Expired) {
-						i++
-						continue
-					}
+	for _, tag := range item.Meta.Tags {
+		if c.tags == nil {
+			c.tags = make(map[string]map[string]struct{})
+		}
 
-					copy(c.payload[i:], c.payload[i+1:])
-					c.payload = c.payload[:len(c.payload)-1]
-				}
-			}()
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
 		}
-	}()
+
+		keys[item.Key] = struct{}{}
+	}
+}
+
+// untag removes item from index of tags, must be called under lock
+func (c *Cache) untag(item *cacheItem) {
+
+	for _, tag := range item.Meta.Tags {
+		keys := c.tags[tag]
+		delete(keys, item.Key)
+
+		if len(keys) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/khevse/image-resizer/service/images/internal/clock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -13,14 +14,27 @@ func TestNew(t *testing.T) {
 	c := New(10, 5, time.Second, time.Minute)
 	defer c.Close()
 
-	require.Equal(t,
-		&Cache{
-			maxFileSize:    10,
-			lifetime:       time.Second,
-			cleanerTimeout: time.Minute,
-			payload:        make([]*cacheItem, 0, 5),
-			closed:         0,
-		}, c)
+	require.Equal(t, int64(10), c.maxFileSize)
+	require.Equal(t, time.Second, c.lifetime)
+	require.Equal(t, time.Minute, c.cleanerTimeout)
+	require.Equal(t, clock.Real{}, c.clock)
+	require.Equal(t, make([]*cacheItem, 0, 5), c.payload)
+	require.Equal(t, int32(0), c.closed)
+}
+
+func TestDisabled(t *testing.T) {
+
+	for _, maxItems := range []int{0, -1} {
+		c := New(10, maxItems, time.Second, time.Minute)
+
+		c.Add("k1", []byte{0x01})
+		c.Add("k2", []byte{0x02})
+
+		_, ok := c.Get("k1")
+		require.False(t, ok, maxItems)
+		require.Equal(t, 0, c.Stats().Entries, maxItems)
+		require.NoError(t, c.Close())
+	}
 }
 
 func TestAddGet(t *testing.T) {
@@ -122,15 +136,18 @@ func TestAddGet(t *testing.T) {
 
 func TestAutoCleaner(t *testing.T) {
 
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
 	{
 		// test: remove from start
 		const Lifetime = time.Second
 
-		c := New(10, 2, Lifetime, Lifetime)
+		clk := clock.NewFake(start)
+		c := NewWithClock(10, 2, Lifetime, Lifetime, clk)
 		defer c.Close()
 
 		c.Add("k1", []byte{0x01})
-		time.Sleep(Lifetime / 2)
+		clk.Advance(Lifetime / 2)
 		c.Add("k2", []byte{0x02})
 
 		func() {
@@ -138,54 +155,53 @@ func TestAutoCleaner(t *testing.T) {
 			c.mu.Lock()
 			defer c.mu.Unlock()
 
-			require.Len(t, c.payload, 2)
-
 			require.Equal(t,
 				[]*cacheItem{
 					{
 						Key:     "k1",
 						Data:    []byte{0x01},
-						Expired: c.payload[0].Expired,
+						Expired: start.Add(Lifetime),
 					},
 					{
 						Key:     "k2",
 						Data:    []byte{0x02},
-						Expired: c.payload[1].Expired,
+						Expired: start.Add(Lifetime * 3 / 2),
 					},
 				},
 				c.payload)
 		}()
 
-		time.Sleep(Lifetime / 2)
+		clk.Advance(Lifetime / 2)
 
 		func() {
 			// after clean
 			c.mu.Lock()
 			defer c.mu.Unlock()
 
-			require.Len(t, c.payload, 1)
-
 			require.Equal(t,
 				[]*cacheItem{
 					{
 						Key:     "k2",
 						Data:    []byte{0x02},
-						Expired: c.payload[0].Expired,
+						Expired: start.Add(Lifetime * 3 / 2),
 					},
 				},
 				c.payload)
 		}()
+
+		require.Equal(t, int64(1), c.Stats().Expirations)
 	}
 
 	{
 		// test: remove from middle
 		const Lifetime = time.Second
 
-		c := New(10, 3, Lifetime, Lifetime)
+		clk := clock.NewFake(start)
+		c := NewWithClock(10, 3, Lifetime, Lifetime, clk)
 		defer c.Close()
 
 		c.Add("k1", []byte{0x01})
-		time.Sleep(Lifetime / 2)
+		clk.Advance(Lifetime / 2)
 		c.Add("k2", []byte{0x02})
 		c.Add("k3", []byte{0x03})
 
@@ -197,54 +213,77 @@ func TestAutoCleaner(t *testing.T) {
 			c.mu.Lock()
 			defer c.mu.Unlock()
 
-			require.Len(t, c.payload, 3)
-
 			require.Equal(t,
 				[]*cacheItem{
 					{
 						Key:     "k2",
 						Data:    []byte{0x02},
-						Expired: c.payload[0].Expired,
+						Expired: start.Add(Lifetime * 3 / 2),
 					},
 					{
 						Key:     "k1",
 						Data:    []byte{0x01},
-						Expired: c.payload[1].Expired,
+						Expired: start.Add(Lifetime),
 					},
 					{
 						Key:     "k3",
 						Data:    []byte{0x03},
-						Expired: c.payload[2].Expired,
+						Expired: start.Add(Lifetime * 3 / 2),
 					},
 				},
 				c.payload)
 		}()
 
-		time.Sleep(Lifetime / 2)
+		clk.Advance(Lifetime / 2)
 
 		func() {
 			// after clean
 			c.mu.Lock()
 			defer c.mu.Unlock()
 
-			require.Len(t, c.payload, 2)
-
 			require.Equal(t,
 				[]*cacheItem{
 					{
 						Key:     "k2",
 						Data:    []byte{0x02},
-						Expired: c.payload[0].Expired,
+						Expired: start.Add(Lifetime * 3 / 2),
 					},
 					{
 						Key:     "k3",
 						Data:    []byte{0x03},
-						Expired: c.payload[1].Expired,
+						Expired: start.Add(Lifetime * 3 / 2),
 					},
 				},
 				c.payload)
 		}()
 	}
+
+	{
+		// test: expired items are not available before clean
+		clk := clock.NewFake(start)
+		c := NewWithClock(10, 2, time.Second, time.Hour, clk)
+		defer c.Close()
+
+		c.Add("k1", []byte{0x01})
+		clk.Advance(time.Second)
+
+		_, ok := c.Get("k1")
+		require.False(t, ok)
+		require.Equal(t, 1, c.Stats().Entries)
+	}
+
+	{
+		// test: closed cache is not cleaned
+		clk := clock.NewFake(start)
+		c := NewWithClock(10, 2, time.Second, time.Second, clk)
+
+		c.Add("k1", []byte{0x01})
+		require.NoError(t, c.Close())
+		require.NoError(t, c.Close())
+
+		clk.Advance(time.Minute)
+		require.Equal(t, 1, c.Stats().Entries)
+	}
 }
 
 func TestMultiThreads(t *testing.T) {
@@ -268,3 +307,266 @@ func TestMultiThreads(t *testing.T) {
 		}()
 	}
 }
+
+func TestDeleteRangeStats(t *testing.T) {
+
+	c := New(10, 3, time.Minute, time.Minute)
+	defer c.Close()
+
+	c.Set("k1", []byte{0x01}, Meta{Source: "http://a"})
+	c.Set("k2", []byte{0x02, 0x02}, Meta{Source: "http://b"})
+	c.Add("k3", []byte{0x03})
+	c.Set("k2", []byte{0x02, 0x02, 0x02}, Meta{Source: "http://b"}) // replace
+
+	_, ok := c.Get("k1")
+	require.True(t, ok)
+	_, ok = c.Get("k4")
+	require.False(t, ok)
+
+	require.Equal(t,
+		Stats{
+			Entries: 3,
+			Bytes:   5,
+			Hits:    1,
+			Misses:  1,
+		},
+		c.Stats())
+
+	{
+		// test: range
+		var entries []Entry
+		c.Range(func(e Entry) bool {
+			require.WithinDuration(t, time.Now().Add(time.Minute), e.Expired, time.Second)
+			e.Expired = time.Time{}
+			entries = append(entries, e)
+			return true
+		})
+
+		require.Equal(t,
+			[]Entry{
+				{Key: "k1", Size: 1, Meta: Meta{Source: "http://a"}},
+				{Key: "k2", Size: 3, Meta: Meta{Source: "http://b"}},
+				{Key: "k3", Size: 1},
+			},
+			entries)
+	}
+
+	{
+		// test: range with delete
+		c.Range(func(e Entry) bool {
+			if e.Meta.Source == "http://b" {
+				require.True(t, c.Delete(e.Key))
+			}
+			return true
+		})
+
+		require.False(t, c.Delete("k2"))
+		_, ok := c.Get("k2")
+		require.False(t, ok)
+	}
+
+	{
+		// test: eviction
+		c.Add("k4", []byte{0x04})
+		c.Add("k5", []byte{0x05})
+
+		stats := c.Stats()
+		require.Equal(t, 3, stats.Entries)
+		require.Equal(t, int64(1), stats.Evictions)
+	}
+
+	{
+		// test: clear
+		require.Equal(t, 3, c.Clear())
+		require.Equal(t, 0, c.Stats().Entries)
+	}
+}
+
+func TestTags(t *testing.T) {
+
+	c := New(10, 3, time.Minute, time.Minute)
+	defer c.Close()
+
+	c.Set("k1", []byte{0x01}, Meta{Tags: []string{"t1", "t2"}})
+	c.Set("k2", []byte{0x02}, Meta{Tags: []string{"t2"}})
+	c.Add("k3", []byte{0x03})
+
+	{
+		// test: lookup
+		data, meta, ok := c.Lookup("k1")
+		require.True(t, ok)
+		require.Equal(t, []byte{0x01}, data)
+		require.Equal(t, Meta{Tags: []string{"t1", "t2"}}, meta)
+	}
+
+	{
+		// test: add tags
+		require.True(t, c.AddTags("k3", 0, "t3", "t3"))
+		require.False(t, c.AddTags("k4", 0, "t3"))
+
+		_, meta, ok := c.Lookup("k3")
+		require.True(t, ok)
+		require.Equal(t, []string{"t3"}, meta.Tags)
+
+		// the number of tags is limited
+		require.True(t, c.AddTags("k3", 2, "t5", "t6", "t7"))
+
+		_, meta, ok = c.Lookup("k3")
+		require.True(t, ok)
+		require.Equal(t, []string{"t3", "t5"}, meta.Tags)
+	}
+
+	{
+		// test: eviction removes tags of the last item (k2)
+		c.Set("k4", []byte{0x04}, Meta{Tags: []string{"t4"}})
+
+		c.mu.Lock()
+		require.Equal(t,
+			map[string]map[string]struct{}{
+				"t1": {"k1": {}},
+				"t2": {"k1": {}},
+				"t3": {"k3": {}},
+				"t4": {"k4": {}},
+				"t5": {"k3": {}},
+			},
+			c.tags)
+		c.mu.Unlock()
+	}
+
+	{
+		// test: delete by tag
+		require.Equal(t, 1, c.DeleteTag("t2"))
+		require.Equal(t, 0, c.DeleteTag("t2"))
+		require.Equal(t, 0, c.DeleteTag("t1"))
+		require.Equal(t, 1, c.DeleteTag("t3"))
+
+		_, ok := c.Get("k4")
+		require.True(t, ok)
+
+		c.mu.Lock()
+		require.Equal(t, map[string]map[string]struct{}{"t4": {"k4": {}}}, c.tags)
+		c.mu.Unlock()
+	}
+}
+
+func TestLookupStale(t *testing.T) {
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+
+	c := NewWithClock(10, 3, time.Minute, time.Hour, clk)
+	defer c.Close()
+
+	c.SetGrace(time.Minute)
+	c.Add("k1", []byte{0x01})
+
+	{
+		// test: fresh
+		_, ok := c.Get("k1")
+		require.True(t, ok)
+
+		data, _, expired, ok := c.LookupStale("k1")
+		require.True(t, ok)
+		require.Equal(t, []byte{0x01}, data)
+		require.Equal(t, now.Add(time.Minute), expired)
+	}
+
+	{
+		// test: stale
+		clk.Advance(time.Minute)
+		require.Equal(t, now.Add(time.Minute), c.Now())
+
+		_, ok := c.Get("k1")
+		require.False(t, ok)
+
+		data, _, _, ok := c.LookupStale("k1")
+		require.True(t, ok)
+		require.Equal(t, []byte{0x01}, data)
+	}
+
+	{
+		// test: outdated
+		clk.Advance(time.Minute)
+
+		_, _, _, ok := c.LookupStale("k1")
+		require.False(t, ok)
+	}
+
+	require.Equal(t, int64(3), c.Stats().Hits)
+	require.Equal(t, int64(2), c.Stats().Misses)
+}
+
+func TestItemLifetime(t *testing.T) {
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+
+	c := NewWithClock(10, 3, time.Minute, time.Hour, clk)
+	defer c.Close()
+
+	c.Add("k1", []byte{0x01})
+	c.Set("k2", []byte{0x02}, Meta{Lifetime: time.Hour})
+
+	_, _, expired, ok := c.LookupStale("k1")
+	require.True(t, ok)
+	require.Equal(t, now.Add(time.Minute), expired)
+
+	_, _, expired, ok = c.LookupStale("k2")
+	require.True(t, ok)
+	require.Equal(t, now.Add(time.Hour), expired)
+}
+
+func TestExtend(t *testing.T) {
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+
+	c := NewWithClock(10, 3, time.Minute, time.Hour, clk)
+	defer c.Close()
+
+	c.SetGrace(time.Minute)
+	c.Add("k1", []byte{0x01})
+	c.Set("k2", []byte{0x02}, Meta{Lifetime: 2 * time.Minute})
+
+	require.False(t, c.Extend("unknown", time.Hour))
+
+	clk.Advance(90 * time.Second)
+
+	{
+		// test: stale value with the cache lifetime
+		_, ok := c.Get("k1")
+		require.False(t, ok)
+
+		require.True(t, c.Extend("k1", 0))
+
+		_, _, expired, ok := c.LookupStale("k1")
+		require.True(t, ok)
+		require.Equal(t, now.Add(150*time.Second), expired)
+	}
+
+	{
+		// test: value with own lifetime
+		require.True(t, c.Extend("k2", 0))
+
+		_, meta, expired, ok := c.LookupStale("k2")
+		require.True(t, ok)
+		require.Equal(t, now.Add(210*time.Second), expired)
+		require.Equal(t, 2*time.Minute, meta.Lifetime)
+	}
+
+	{
+		// test: new lifetime
+		require.True(t, c.Extend("k2", time.Hour))
+
+		_, meta, expired, ok := c.LookupStale("k2")
+		require.True(t, ok)
+		require.Equal(t, now.Add(90*time.Second+time.Hour), expired)
+		require.Equal(t, time.Hour, meta.Lifetime)
+	}
+
+	{
+		// test: outdated
+		clk.Advance(3 * time.Minute)
+		require.False(t, c.Extend("k1", 0))
+	}
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"encoding/gob"
+	"io"
+	"sync/atomic"
+)
+
+// snapshotVersion - version of snapshot format
+const snapshotVersion = 1
+
+type snapshotHeader struct {
+	Version int
+	Items   int
+}
+
+// Save writes cache content to the writer
+func (c *Cache) Save(w io.Writer) error {
+
+	c.mu.Lock()
+	items := make([]cacheItem, 0, len(c.payload))
+	for _, item := range c.payload {
+		items = append(items, *item)
+	}
+	c.mu.Unlock()
+
+	enc := gob.NewEncoder(w)
+
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Items: len(items)}); err != nil {
+		return err
+	}
+
+	for i := range items {
+		if err := enc.Encode(&items[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load reads cache content saved by Save, expired items and items which don't fit to the cache are skipped.
+// Returns number of loaded items. Use reader with io.ByteReader interface (e.g. bufio.Reader) to read several
+// snapshots from one stream.
+func (c *Cache) Load(r io.Reader) (int, error) {
+
+	dec := gob.NewDecoder(r)
+
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, err
+	}
+
+	if header.Version != snapshotVersion {
+		return 0, errUnknownSnapshot
+	}
+
+	maxFileSize := atomic.LoadInt64(&c.maxFileSize)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	var loaded int
+	for i := 0; i < header.Items; i++ {
+		item := new(cacheItem)
+		if err := dec.Decode(item); err != nil {
+			return loaded, err
+		}
+
+		if !now.Before(item.Expired) || len(item.Data) == 0 || int64(len(item.Data)) > maxFileSize ||
+			c.indexOf(item.Key) >= 0 || len(c.payload) == cap(c.payload) {
+			continue
+		}
+
+		c.payload = append(c.payload, item)
+		c.tag(item)
+		loaded++
+	}
+
+	return loaded, nil
+}
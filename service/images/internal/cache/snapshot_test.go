@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	src := New(10, 3, time.Minute, time.Minute)
+	defer src.Close()
+
+	src.Set("k1", []byte{0x01}, Meta{Source: "http://a", Tags: []string{"t1"}})
+	src.Add("k2", []byte{0x02})
+	src.Add("k3", []byte{0x03})
+
+	// expired item
+	src.mu.Lock()
+	src.payload[2].Expired = time.Now().Add(-time.Second)
+	src.mu.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, src.Save(buf))
+	require.NoError(t, src.Save(buf)) // two snapshots in one stream
+
+	{
+		// test: load
+		r := bufio.NewReader(bytes.NewReader(buf.Bytes()))
+
+		dst := New(10, 3, time.Minute, time.Minute)
+		defer dst.Close()
+
+		loaded, err := dst.Load(r)
+		require.NoError(t, err)
+		require.Equal(t, 2, loaded)
+
+		src.mu.Lock()
+		require.Len(t, dst.payload, 2)
+		for i, item := range dst.payload {
+			exp := src.payload[i]
+			require.Equal(t, exp.Key, item.Key)
+			require.Equal(t, exp.Data, item.Data)
+			require.Equal(t, exp.Meta, item.Meta)
+			require.True(t, exp.Expired.Equal(item.Expired))
+		}
+		src.mu.Unlock()
+
+		require.Equal(t, map[string]map[string]struct{}{"t1": {"k1": {}}}, dst.tags)
+
+		// test: the second snapshot, items already exist
+		loaded, err = dst.Load(r)
+		require.NoError(t, err)
+		require.Equal(t, 0, loaded)
+	}
+
+	{
+		// test: smaller cache
+		dst := New(10, 1, time.Minute, time.Minute)
+		defer dst.Close()
+
+		loaded, err := dst.Load(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, 1, loaded)
+
+		_, ok := dst.Get("k1")
+		require.True(t, ok)
+	}
+
+	{
+		// test: invalid snapshot
+		dst := New(10, 1, time.Minute, time.Minute)
+		defer dst.Close()
+
+		_, err := dst.Load(bytes.NewReader([]byte("invalid")))
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,145 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock - source of time
+type Clock interface {
+	// Now returns current time
+	Now() time.Time
+	// Every calls fn every interval until stop is called
+	Every(interval time.Duration, fn func()) (stop func())
+}
+
+// Real clock based on the system time
+type Real struct{}
+
+// Now returns current time
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Every calls fn in separate goroutine every interval until stop is called
+func (Real) Every(interval time.Duration, fn func()) (stop func()) {
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+type timer struct {
+	id       int
+	interval time.Duration
+	next     time.Time
+	fn       func()
+}
+
+// Fake clock for tests, the time is changed only by Advance
+type Fake struct {
+	now    time.Time
+	timers map[int]*timer
+	lastID int
+
+	mu sync.Mutex
+}
+
+// NewFake returns fake clock with the given current time
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:    now,
+		timers: make(map[int]*timer),
+	}
+}
+
+// Now returns current time
+func (f *Fake) Now() time.Time {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// Every calls fn every interval of the fake time, fn is called synchronously by Advance
+func (f *Fake) Every(interval time.Duration, fn func()) (stop func()) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastID++
+	id := f.lastID
+
+	f.timers[id] = &timer{
+		id:       id,
+		interval: interval,
+		next:     f.now.Add(interval),
+		fn:       fn,
+	}
+
+	return func() {
+		f.mu.Lock()
+		delete(f.timers, id)
+		f.mu.Unlock()
+	}
+}
+
+// Advance moves the time forward and calls functions of all elapsed intervals in order of time
+func (f *Fake) Advance(d time.Duration) {
+
+	f.mu.Lock()
+	end := f.now.Add(d)
+	f.mu.Unlock()
+
+	for {
+		f.mu.Lock()
+
+		due := make([]*timer, 0, len(f.timers))
+		for _, t := range f.timers {
+			if !t.next.After(end) {
+				due = append(due, t)
+			}
+		}
+
+		if len(due) == 0 {
+			f.now = end
+			f.mu.Unlock()
+			return
+		}
+
+		sort.Slice(due, func(i, j int) bool {
+			if due[i].next.Equal(due[j].next) {
+				return due[i].id < due[j].id
+			}
+			return due[i].next.Before(due[j].next)
+		})
+
+		t := due[0]
+		f.now = t.next
+		t.next = t.next.Add(t.interval)
+		fn := t.fn
+
+		f.mu.Unlock()
+
+		fn() // without lock, so fn may use the clock
+	}
+}
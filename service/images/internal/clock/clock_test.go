@@ -0,0 +1,55 @@
+package clock
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake(t *testing.T) {
+
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	var calls []string
+	stop1 := c.Every(time.Second, func() { calls = append(calls, "1s:"+c.Now().Sub(start).String()) })
+	stop2 := c.Every(time.Second*2, func() { calls = append(calls, "2s:"+c.Now().Sub(start).String()) })
+
+	c.Advance(time.Second / 2)
+	require.Nil(t, calls)
+	require.Equal(t, start.Add(time.Second/2), c.Now())
+
+	c.Advance(time.Second * 2)
+	require.Equal(t, []string{"1s:1s", "1s:2s", "2s:2s"}, calls)
+	require.Equal(t, start.Add(time.Second*5/2), c.Now())
+
+	stop1()
+	calls = nil
+
+	c.Advance(time.Second * 2)
+	require.Equal(t, []string{"2s:4s"}, calls)
+
+	stop2()
+	calls = nil
+
+	c.Advance(time.Minute)
+	require.Nil(t, calls)
+}
+
+func TestReal(t *testing.T) {
+
+	var c Clock = Real{}
+	require.WithinDuration(t, time.Now(), c.Now(), time.Second)
+
+	var calls int32
+	stop := c.Every(time.Millisecond, func() { atomic.AddInt32(&calls, 1) })
+
+	for atomic.LoadInt32(&calls) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stop()
+	stop() // repeated stop is allowed
+}
@@ -0,0 +1,251 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Networks of addresses which are denied by default
+var (
+	Loopback    = mustParseCIDR("127.0.0.0/8", "::1/128")
+	Private     = mustParseCIDR("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+	LinkLocal   = mustParseCIDR("169.254.0.0/16", "fe80::/10")
+	Multicast   = mustParseCIDR("224.0.0.0/4", "ff00::/8")
+	Unspecified = mustParseCIDR("0.0.0.0/8", "::/128")
+	NAT64       = mustParseCIDR("64:ff9b::/96", "64:ff9b:1::/48") // IPv6 addresses translated to IPv4 ones, including the denied
+)
+
+var namedNetworks = map[string][]*net.IPNet{
+	"loopback":    Loopback,
+	"private":     Private,
+	"link-local":  LinkLocal,
+	"multicast":   Multicast,
+	"unspecified": Unspecified,
+	"nat64":       NAT64,
+}
+
+const (
+	defaultMaxRedirects   = 10
+	defaultConnectTimeout = 30 * time.Second
+)
+
+// DeniedError - the resource is denied by the client policy
+type DeniedError struct {
+	msg string
+}
+
+func (e *DeniedError) Error() string {
+	return e.msg
+}
+
+// IsDenied returns true if the request failed because the resource is denied
+func IsDenied(err error) bool {
+	var e *DeniedError
+	return errors.As(err, &e)
+}
+
+// Config of client
+type Config struct {
+	Deny         []*net.IPNet // denied networks, DefaultDeny is used if it's nil
+	Allow        []*net.IPNet // networks which are allowed even if they are denied
+	MaxRedirects int          // max number of redirects, the default value is used if it's zero
+
+	// CheckRedirect is additional check of redirects, the redirect is denied if it returns error
+	CheckRedirect func(req *http.Request, via []*http.Request) error
+
+	ConnectTimeout time.Duration // timeout of connection, the default value is used if it's zero
+	HeaderTimeout  time.Duration // timeout of waiting for response headers, no timeout if it's zero
+	Timeout        time.Duration // total timeout of request including retries and reading of body, no timeout if it's zero
+
+	Retries       int           // number of retries of failed idempotent requests
+	RetryDelay    time.Duration // base delay between retries, it's doubled after each retry and randomized
+	MaxRetryDelay time.Duration // max delay between retries, no limit if it's zero
+}
+
+// DefaultDeny returns networks which are denied by default: loopback, private, link-local, multicast, unspecified and NAT64
+func DefaultDeny() []*net.IPNet {
+
+	var list []*net.IPNet
+	for _, networks := range [][]*net.IPNet{Loopback, Private, LinkLocal, Multicast, Unspecified, NAT64} {
+		list = append(list, networks...)
+	}
+
+	return list
+}
+
+// ParseNetworks parses CIDR networks or names of networks: loopback, private, link-local, multicast, unspecified, nat64
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+
+	var networks []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if named, ok := namedNetworks[strings.ToLower(s)]; ok {
+			networks = append(networks, named...)
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// NewClient returns HTTP client which resolves host once and dials only allowed addresses,
+// it follows redirects only to http and https resources.
+func NewClient(cfg Config) *http.Client {
+
+	maxRedirects := cfg.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	deny := cfg.Deny
+	if deny == nil {
+		deny = DefaultDeny()
+	}
+
+	connectTimeout := cfg.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+
+	d := &dialer{
+		dialer: &net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		},
+		deny:  deny,
+		allow: cfg.Allow,
+	}
+
+	transport := &http.Transport{
+		// the proxy is not used, because the client must dial the resource by itself
+		DialContext:           d.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ResponseHeaderTimeout: cfg.HeaderTimeout,
+	}
+
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &retryTransport{
+			base:     transport,
+			retries:  cfg.Retries,
+			delay:    cfg.RetryDelay,
+			maxDelay: cfg.MaxRetryDelay,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+
+			if err := checkScheme(req.URL.Scheme); err != nil {
+				return err
+			}
+
+			if cfg.CheckRedirect != nil {
+				if err := cfg.CheckRedirect(req, via); err != nil {
+					return &DeniedError{msg: "redirect to " + req.URL.Host + " is denied: " + err.Error()}
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+func checkScheme(scheme string) error {
+
+	switch strings.ToLower(scheme) {
+	case "http", "https":
+		return nil
+	}
+
+	return &DeniedError{msg: "scheme " + scheme + " is denied"}
+}
+
+type dialer struct {
+	dialer *net.Dialer
+	deny   []*net.IPNet
+	allow  []*net.IPNet
+}
+
+// DialContext resolves host once, checks all its addresses and dials them,
+// so the host can't be resolved to other address after the check.
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ipAddr := range addrs {
+		if !d.allowed(ipAddr.IP) {
+			return nil, &DeniedError{msg: "address " + ipAddr.String() + " of host " + host + " is denied"}
+		}
+	}
+
+	var lastErr error
+	for _, ipAddr := range addrs {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ipAddr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no addresses of host " + host)
+	}
+
+	return nil, lastErr
+}
+
+func (d *dialer) allowed(ip net.IP) bool {
+	return contains(d.allow, ip) || !contains(d.deny, ip)
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mustParseCIDR(list ...string) []*net.IPNet {
+
+	networks := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
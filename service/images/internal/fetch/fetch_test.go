@@ -0,0 +1,157 @@
+package fetch
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetworks(t *testing.T) {
+
+	networks, err := ParseNetworks([]string{"loopback", " 10.1.0.0/16", ""})
+	require.NoError(t, err)
+	require.Len(t, networks, len(Loopback)+1)
+	require.Equal(t, "10.1.0.0/16", networks[len(networks)-1].String())
+
+	_, err = ParseNetworks([]string{"unknown"})
+	require.Error(t, err)
+}
+
+func TestAllowed(t *testing.T) {
+
+	d := &dialer{deny: DefaultDeny(), allow: mustParseCIDR("10.1.0.0/16")}
+
+	testCases := []struct {
+		IP  string
+		Exp bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"10.1.0.1", true},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"64:ff9b::7f00:1", false},
+		{"64:ff9b:1::808:808", false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.Exp, d.allowed(net.ParseIP(tc.IP)), tc.IP)
+	}
+}
+
+func TestClient(t *testing.T) {
+
+	// the servers listen on different loopback addresses
+	denied := helperNewServer(t, "127.0.0.2:0", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("denied"))
+	}))
+	defer denied.Close()
+
+	allowed := helperNewServer(t, "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/redirect/denied":
+			http.Redirect(w, req, denied.URL, http.StatusFound)
+		case "/redirect/file":
+			http.Redirect(w, req, "file:///etc/passwd", http.StatusFound)
+		case "/redirect/self":
+			http.Redirect(w, req, "/", http.StatusFound)
+		case "/redirect/other":
+			http.Redirect(w, req, "/other", http.StatusFound)
+		default:
+			w.Write([]byte("allowed"))
+		}
+	}))
+	defer allowed.Close()
+
+	get := func(client *http.Client, u string) error {
+		t.Helper()
+
+		res, err := client.Get(u)
+		if err != nil {
+			return err
+		}
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		return nil
+	}
+
+	{
+		// test: loopback is denied by default
+		client := NewClient(Config{})
+
+		err := get(client, allowed.URL)
+		require.Error(t, err)
+		require.True(t, IsDenied(err))
+
+		err = get(client, "http://169.254.169.254/latest/meta-data/")
+		require.True(t, IsDenied(err))
+	}
+
+	{
+		client := NewClient(Config{Allow: mustParseCIDR("127.0.0.1/32")})
+
+		require.NoError(t, get(client, allowed.URL))
+		require.NoError(t, get(client, allowed.URL+"/redirect/self"))
+
+		// test: every redirect is checked
+		err := get(client, allowed.URL+"/redirect/denied")
+		require.True(t, IsDenied(err))
+
+		err = get(client, allowed.URL+"/redirect/file")
+		require.True(t, IsDenied(err))
+	}
+
+	{
+		// test: additional check of redirects
+		client := NewClient(Config{
+			Allow: mustParseCIDR("127.0.0.1/32"),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if req.URL.Path == "/other" {
+					return errors.New("invalid path")
+				}
+				return nil
+			},
+		})
+
+		require.NoError(t, get(client, allowed.URL+"/redirect/self"))
+		require.NoError(t, get(client, allowed.URL+"/other"))
+
+		err := get(client, allowed.URL+"/redirect/other")
+		require.True(t, IsDenied(err))
+	}
+
+	{
+		// test: nothing is denied
+		client := NewClient(Config{Deny: []*net.IPNet{}})
+		require.NoError(t, get(client, denied.URL))
+	}
+}
+
+func helperNewServer(t *testing.T, addr string, handler http.Handler) *httptest.Server {
+
+	l, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+
+	svr := httptest.NewUnstartedServer(handler)
+	svr.Listener.Close()
+	svr.Listener = l
+	svr.Start()
+
+	return svr
+}
@@ -0,0 +1,82 @@
+package fetch
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// retryTransport retries failed idempotent requests with exponential backoff and jitter
+type retryTransport struct {
+	base     http.RoundTripper
+	retries  int
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if !isIdempotent(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := t.base.RoundTrip(req)
+		if attempt >= t.retries || !isRetryable(req, res, err) {
+			return res, err
+		}
+
+		if res != nil {
+			// the connection may be reused after the body is read
+			io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(t.backoff(attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns random delay before the retry, the max delay is doubled after each attempt ("full jitter")
+func (t *retryTransport) backoff(attempt int) time.Duration {
+
+	delay := t.delay << uint(attempt)
+	if delay <= 0 || (t.maxDelay > 0 && delay > t.maxDelay) {
+		delay = t.maxDelay // overflow or limit
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+func isIdempotent(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Body == nil
+}
+
+func isRetryable(req *http.Request, res *http.Response, err error) bool {
+
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		return !IsDenied(err)
+	}
+
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,120 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetries(t *testing.T) {
+
+	var requests int32
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch n := atomic.AddInt32(&requests, 1); {
+		case req.URL.Path == "/not-found":
+			http.NotFound(w, req)
+		case n%3 != 0:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer svr.Close()
+
+	get := func(client *http.Client, path string, expStatus int, expRequests int32) {
+		t.Helper()
+
+		atomic.StoreInt32(&requests, 0)
+
+		res, err := client.Get(svr.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, expStatus, res.StatusCode)
+		require.Equal(t, expRequests, atomic.LoadInt32(&requests))
+	}
+
+	cfg := Config{
+		Allow:      Loopback,
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	}
+
+	get(NewClient(cfg), "/", http.StatusOK, 3)
+	get(NewClient(cfg), "/not-found", http.StatusNotFound, 1)
+
+	cfg.Retries = 1
+	get(NewClient(cfg), "/", http.StatusServiceUnavailable, 2)
+
+	{
+		// test: the request is canceled while waiting for retry
+		cfg.RetryDelay = time.Hour
+		cfg.MaxRetryDelay = time.Hour
+		atomic.StoreInt32(&requests, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		req, err := http.NewRequest(http.MethodGet, svr.URL, nil)
+		require.NoError(t, err)
+
+		begin := time.Now()
+		_, err = NewClient(cfg).Do(req.WithContext(ctx))
+		require.Error(t, err)
+		require.True(t, time.Since(begin) < time.Minute)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+
+	tr := &retryTransport{delay: 100 * time.Millisecond, maxDelay: time.Second}
+
+	for attempt := 0; attempt < 100; attempt++ {
+		limit := 100 * time.Millisecond << uint(attempt)
+		if attempt > 3 {
+			limit = time.Second
+		}
+
+		delay := tr.backoff(attempt)
+		require.True(t, delay >= 0 && delay <= limit, "attempt %d: %s", attempt, delay)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow-body" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer svr.Close()
+
+	{
+		client := NewClient(Config{Allow: Loopback, HeaderTimeout: 50 * time.Millisecond})
+
+		_, err := client.Get(svr.URL)
+		require.Error(t, err)
+
+		res, err := client.Get(svr.URL + "/slow-body")
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+	}
+
+	{
+		client := NewClient(Config{Allow: Loopback, Timeout: 50 * time.Millisecond})
+
+		res, err := client.Get(svr.URL + "/slow-body")
+		require.NoError(t, err)
+
+		_, err = res.Body.Read(make([]byte, 1))
+		require.Error(t, err)
+		require.NoError(t, res.Body.Close())
+	}
+}
@@ -0,0 +1,127 @@
+package flight
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError - error of call whose function panicked, the stack of panic is kept for logging
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+type call struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+	dups int
+
+	ctx    context.Context
+	cancel func()
+	refs   int // number of callers waiting for the result
+}
+
+// Group deduplicates concurrent calls with the same key
+type Group struct {
+	calls map[string]*call
+	mu    sync.Mutex
+}
+
+// Do executes fn once for all concurrent callers with the same key,
+// shared is true if the result was produced by another caller.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (val interface{}, shared bool, err error) {
+
+	c, ok := g.join(key)
+	if ok {
+		<-c.done
+		return c.val, true, c.err
+	}
+
+	g.run(key, c, func(context.Context) (interface{}, error) {
+		return fn()
+	})
+
+	return c.val, false, c.err
+}
+
+// DoContext is like Do, but fn gets context which is canceled when contexts of all waiting callers are done,
+// so fn isn't canceled while somebody waits for its result. The caller stops waiting when its context is done.
+func (g *Group) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (val interface{}, shared bool, err error) {
+
+	c, ok := g.join(key)
+	if !ok {
+		go g.run(key, c, fn)
+	}
+
+	select {
+	case <-c.done:
+		return c.val, ok, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.refs--
+		if c.refs == 0 {
+			// the canceled call isn't joined by new callers
+			c.cancel()
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+
+		return nil, ok, ctx.Err()
+	}
+}
+
+// join returns call with the key, ok is false if the call is new and it must be run
+func (g *Group) join(key string) (c *call, ok bool) {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+
+	if c, ok = g.calls[key]; ok {
+		c.dups++
+	} else {
+		c = &call{done: make(chan struct{})}
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		g.calls[key] = c
+	}
+
+	c.refs++
+
+	return c, ok
+}
+
+func (g *Group) run(key string, c *call, fn func(ctx context.Context) (interface{}, error)) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			// the waiters get error instead of crash of the process, fn may run in its own goroutine
+			c.val, c.err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+
+		g.mu.Lock()
+		g.forget(key, c)
+		g.mu.Unlock()
+
+		c.cancel()
+		close(c.done)
+	}()
+
+	c.val, c.err = fn(c.ctx)
+}
+
+// forget removes the call from the group if it isn't replaced by new call with the same key, g.mu must be locked
+func (g *Group) forget(key string, c *call) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
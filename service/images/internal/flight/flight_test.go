@@ -0,0 +1,269 @@
+package flight
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo(t *testing.T) {
+
+	{
+		// test: single call
+		var g Group
+		val, shared, err := g.Do("k1", func() (interface{}, error) { return []byte{0x01}, nil })
+		require.NoError(t, err)
+		require.False(t, shared)
+		require.Equal(t, []byte{0x01}, val)
+	}
+
+	{
+		// test: error
+		var g Group
+		_, _, err := g.Do("k1", func() (interface{}, error) { return nil, errors.New("fail") })
+		require.EqualError(t, err, "fail")
+	}
+
+	{
+		// test: concurrent calls
+		const Threads = 10
+
+		var (
+			g       Group
+			calls   int32
+			sharedN int32
+			wg      sync.WaitGroup
+		)
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, shared, err := g.Do("k1", func() (interface{}, error) {
+				close(started)
+				<-release
+				atomic.AddInt32(&calls, 1)
+				return []byte{0x01}, nil
+			})
+			require.NoError(t, err)
+			require.False(t, shared)
+			require.Equal(t, []byte{0x01}, val)
+		}()
+
+		<-started
+
+		for i := 0; i < Threads; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				val, shared, err := g.Do("k1", func() (interface{}, error) {
+					atomic.AddInt32(&calls, 1)
+					return []byte{0x02}, nil
+				})
+				require.NoError(t, err)
+				if shared {
+					atomic.AddInt32(&sharedN, 1)
+				}
+				require.Equal(t, []byte{0x01}, val)
+			}()
+		}
+
+		for {
+			// wait for all callers
+			g.mu.Lock()
+			dups := g.calls["k1"].dups
+			g.mu.Unlock()
+
+			if dups == Threads {
+				break
+			}
+			runtime.Gosched()
+		}
+
+		close(release)
+		wg.Wait()
+
+		require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		require.Equal(t, int32(Threads), atomic.LoadInt32(&sharedN))
+	}
+}
+
+func TestDoContext(t *testing.T) {
+
+	{
+		// test: the call isn't canceled while somebody waits for it
+		var g Group
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+
+		fn := func(ctx context.Context) (interface{}, error) {
+			close(started)
+			select {
+			case <-release:
+				return []byte{0x01}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		result1 := make(chan error, 1)
+		go func() {
+			_, _, err := g.DoContext(ctx1, "k1", fn)
+			result1 <- err
+		}()
+
+		<-started
+
+		result2 := make(chan interface{}, 1)
+		go func() {
+			val, shared, err := g.DoContext(context.Background(), "k1", fn)
+			require.NoError(t, err)
+			require.True(t, shared)
+			result2 <- val
+		}()
+
+		for {
+			// wait for the second caller
+			g.mu.Lock()
+			refs := g.calls["k1"].refs
+			g.mu.Unlock()
+
+			if refs == 2 {
+				break
+			}
+			runtime.Gosched()
+		}
+
+		cancel1()
+		require.Equal(t, context.Canceled, <-result1)
+
+		close(release)
+		require.Equal(t, []byte{0x01}, <-result2)
+	}
+
+	{
+		// test: the call is canceled when all callers are gone
+		var g Group
+
+		canceled := make(chan struct{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, _, err := g.DoContext(ctx, "k1", func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		})
+		require.Equal(t, context.Canceled, err)
+
+		<-canceled
+	}
+
+	{
+		// test: the canceled call isn't joined by the next caller, even if it isn't returned yet
+		var g Group
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		result1 := make(chan error, 1)
+		go func() {
+			_, _, err := g.DoContext(ctx, "k1", func(context.Context) (interface{}, error) {
+				close(started)
+				<-release // the cancellation is ignored
+				return []byte{0x01}, nil
+			})
+			result1 <- err
+		}()
+
+		<-started
+
+		g.mu.Lock()
+		first := g.calls["k1"]
+		g.mu.Unlock()
+
+		cancel()
+		require.Equal(t, context.Canceled, <-result1)
+
+		val, shared, err := g.DoContext(context.Background(), "k1", func(context.Context) (interface{}, error) {
+			return []byte{0x02}, nil
+		})
+		require.NoError(t, err)
+		require.False(t, shared)
+		require.Equal(t, []byte{0x02}, val)
+
+		// the first call doesn't remove the next one when it returns
+		next, ok := g.join("k1")
+		require.False(t, ok)
+
+		close(release)
+		<-first.done
+
+		g.mu.Lock()
+		require.Equal(t, next, g.calls["k1"])
+		g.mu.Unlock()
+	}
+}
+
+func TestPanic(t *testing.T) {
+
+	{
+		// test: Do
+		var g Group
+		_, _, err := g.Do("k1", func() (interface{}, error) { panic("fail") })
+		require.EqualError(t, err, "panic: fail")
+
+		e, ok := err.(*PanicError)
+		require.True(t, ok)
+		require.Equal(t, "fail", e.Value)
+		require.NotEmpty(t, e.Stack)
+	}
+
+	{
+		// test: all callers of DoContext get the error
+		var g Group
+
+		release := make(chan struct{})
+		results := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				_, _, err := g.DoContext(context.Background(), "k1", func(context.Context) (interface{}, error) {
+					<-release
+					panic("fail")
+				})
+				results <- err
+			}()
+		}
+
+		for {
+			g.mu.Lock()
+			c := g.calls["k1"]
+			joined := c != nil && c.refs == 2
+			g.mu.Unlock()
+
+			if joined {
+				break
+			}
+			runtime.Gosched()
+		}
+
+		close(release)
+		require.EqualError(t, <-results, "panic: fail")
+		require.EqualError(t, <-results, "panic: fail")
+
+		g.mu.Lock()
+		require.Empty(t, g.calls)
+		g.mu.Unlock()
+	}
+}
@@ -0,0 +1,248 @@
+package origin
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/s3"
+)
+
+// Origin - allowed resource of source images with its settings
+type Origin struct {
+	Host       string // host with optional port, "*.example.com" matches all subdomains of example.com
+	PathPrefix string // prefix of path of source images, all paths match if it's empty
+
+	Timeout       time.Duration // timeout of loading of source image, no timeout if it's zero
+	MaxSourceSize int64         // max size of source image, no limit if it's zero
+	Header        http.Header   // additional headers of requests to the origin
+
+	// credentials of basic authentication
+	Username string
+	Password string
+
+	// S3 - bucket of source images, the path of URL is the key of object in the bucket,
+	// the resource of URL isn't requested if it's set
+	S3 *s3.Config
+
+	// Sources - ordered list of sources of images, the next source is tried if the previous one fails,
+	// the S3 field isn't used if it's set
+	Sources []Source
+}
+
+// conditions of fallback to the next source
+const (
+	FallbackError    = "error" // connection errors, timeouts and open circuit breakers
+	Fallback5xx      = "5xx"   // responses with 5xx status
+	FallbackNotFound = "404"   // missing images
+)
+
+// Source - source of images of origin, the path of image URL is appended to its base URL,
+// it's the key of object in the bucket or the name of file in the directory.
+// The image URL is requested if neither of URL, S3 and Dir is set.
+type Source struct {
+	Name string // name of source sent in response header, the kind of source (http, s3, file) if it's empty, it's unique in chain
+
+	URL      string     // base URL of HTTP resource
+	S3       *s3.Config // bucket
+	Dir      string     // local directory
+	Symlinks bool       // symlinks in the directory are followed if their targets are inside it
+
+	FallbackOn []string // conditions of fallback to the next source, all of them if it's nil
+}
+
+// Fallback returns true if the next source is tried on the condition
+func (s *Source) Fallback(condition string) bool {
+
+	if s.FallbackOn == nil {
+		return true
+	}
+
+	for _, c := range s.FallbackOn {
+		if c == condition {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match returns true if URL of source image belongs to the origin
+func (o *Origin) Match(u *url.URL) bool {
+	return o.matchHost(u) && o.matchPath(u)
+}
+
+func (o *Origin) matchHost(u *url.URL) bool {
+
+	pattern := strings.ToLower(o.Host)
+
+	host := strings.ToLower(u.Hostname())
+	if _, _, err := net.SplitHostPort(pattern); err == nil {
+		// the port must be the same if the pattern has it
+		host = net.JoinHostPort(host, u.Port())
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return host == pattern
+}
+
+func (o *Origin) matchPath(u *url.URL) bool {
+
+	if o.PathPrefix == "" {
+		return true
+	}
+
+	// the path is cleaned, so "/images/../private" doesn't match "/images"
+	p := path.Clean("/" + u.Path)
+	prefix := strings.TrimSuffix(o.PathPrefix, "/")
+
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// List of origins
+type List []Origin
+
+// Match returns the first origin which the URL belongs to
+func (l List) Match(u *url.URL) (*Origin, bool) {
+
+	for i := range l {
+		if l[i].Match(u) {
+			return &l[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// jsonOrigin - origin in JSON file
+type jsonOrigin struct {
+	Host          string            `json:"host"`
+	PathPrefix    string            `json:"path_prefix"`
+	Timeout       string            `json:"timeout"` // e.g. "10s"
+	MaxSourceSize int64             `json:"max_source_size"`
+	Header        map[string]string `json:"header"`
+	Username      string            `json:"username"`
+	Password      string            `json:"password"`
+	S3            *jsonS3           `json:"s3"`
+	Sources       []jsonSource      `json:"sources"`
+}
+
+// jsonSource - source of origin in JSON file
+type jsonSource struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	S3         *jsonS3  `json:"s3"`
+	Dir        string   `json:"dir"`
+	Symlinks   bool     `json:"symlinks"`
+	FallbackOn []string `json:"fallback_on"`
+}
+
+// jsonS3 - bucket of origin in JSON file
+type jsonS3 struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+func (s *jsonS3) config() *s3.Config {
+
+	if s == nil {
+		return nil
+	}
+
+	return &s3.Config{
+		Endpoint:    s.Endpoint,
+		Region:      s.Region,
+		Bucket:      s.Bucket,
+		Prefix:      s.Prefix,
+		Credentials: s3.Credentials{AccessKey: s.AccessKey, SecretKey: s.SecretKey},
+	}
+}
+
+// Load reads list of origins from JSON file
+func Load(filename string) (List, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []jsonOrigin
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	origins := make(List, 0, len(list))
+	for _, item := range list {
+		o := Origin{
+			Host:          item.Host,
+			PathPrefix:    item.PathPrefix,
+			MaxSourceSize: item.MaxSourceSize,
+			Username:      item.Username,
+			Password:      item.Password,
+		}
+
+		if item.Timeout != "" {
+			if o.Timeout, err = time.ParseDuration(item.Timeout); err != nil {
+				return nil, err
+			}
+		}
+
+		o.S3 = item.S3.config()
+
+		names := make(map[string]bool, len(item.Sources))
+		for _, src := range item.Sources {
+			if src.Name != "" {
+				if names[src.Name] {
+					return nil, errors.New("duplicate name of source: " + src.Name)
+				}
+				names[src.Name] = true
+			}
+
+			if src.URL != "" {
+				if _, err := url.Parse(src.URL); err != nil {
+					return nil, err
+				}
+			}
+
+			for _, c := range src.FallbackOn {
+				if c != FallbackError && c != Fallback5xx && c != FallbackNotFound {
+					return nil, errors.New("unknown condition of fallback: " + c)
+				}
+			}
+
+			o.Sources = append(o.Sources, Source{
+				Name:       src.Name,
+				URL:        src.URL,
+				S3:         src.S3.config(),
+				Dir:        src.Dir,
+				Symlinks:   src.Symlinks,
+				FallbackOn: src.FallbackOn,
+			})
+		}
+
+		if len(item.Header) > 0 {
+			o.Header = make(http.Header, len(item.Header))
+			for name, value := range item.Header {
+				o.Header.Set(name, value)
+			}
+		}
+
+		origins = append(origins, o)
+	}
+
+	return origins, nil
+}
@@ -0,0 +1,137 @@
+package origin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+
+	origins := List{
+		{Host: "images.example.com", PathPrefix: "/public/"},
+		{Host: "*.cdn.example.com"},
+		{Host: "localhost:8080"},
+	}
+
+	testCases := []struct {
+		URL   string
+		Index int // -1 if the URL doesn't match
+	}{
+		{"https://images.example.com/public/a.jpg", 0},
+		{"https://IMAGES.example.com:8443/public/a.jpg", 0},
+		{"https://images.example.com/public", 0},
+		{"https://images.example.com/private/a.jpg", -1},
+		{"https://images.example.com/public-private/a.jpg", -1},
+		{"https://images.example.com/public/../private/a.jpg", -1},
+		{"https://images.example.com.evil.com/public/a.jpg", -1},
+		{"https://a.cdn.example.com/a.jpg", 1},
+		{"https://a.b.cdn.example.com/a.jpg", 1},
+		{"https://cdn.example.com/a.jpg", -1},
+		{"https://evilcdn.example.com/a.jpg", -1},
+		{"http://localhost:8080/a.jpg", 2},
+		{"http://localhost/a.jpg", -1},
+		{"http://localhost:8081/a.jpg", -1},
+	}
+
+	for _, tc := range testCases {
+		u, err := url.Parse(tc.URL)
+		require.NoError(t, err)
+
+		o, ok := origins.Match(u)
+		if tc.Index < 0 {
+			require.False(t, ok, tc.URL)
+			continue
+		}
+
+		require.True(t, ok, tc.URL)
+		require.Equal(t, &origins[tc.Index], o, tc.URL)
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "origins")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "origins.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`[
+		{"host": "*.example.com", "path_prefix": "/images", "timeout": "5s", "max_source_size": 1024,
+		 "header": {"x-api-key": "key"}, "username": "user", "password": "secret"},
+		{"host": "partner.com"},
+		{"host": "storage.example.com", "s3": {"endpoint": "http://minio:9000", "bucket": "images", "prefix": "originals/",
+		 "access_key": "key", "secret_key": "secret"}},
+		{"host": "fallback.example.com", "sources": [
+			{"name": "cdn", "fallback_on": ["error", "5xx"]},
+			{"url": "https://backup.example.com/images", "s3": null},
+			{"name": "local", "dir": "/var/images", "symlinks": true}
+		]}
+	]`), 0600))
+
+	origins, err := Load(filename)
+	require.NoError(t, err)
+	require.Equal(t, List{
+		{
+			Host:          "*.example.com",
+			PathPrefix:    "/images",
+			Timeout:       5 * time.Second,
+			MaxSourceSize: 1024,
+			Header:        http.Header{"X-Api-Key": {"key"}},
+			Username:      "user",
+			Password:      "secret",
+		},
+		{Host: "partner.com"},
+		{
+			Host: "storage.example.com",
+			S3: &s3.Config{
+				Endpoint:    "http://minio:9000",
+				Bucket:      "images",
+				Prefix:      "originals/",
+				Credentials: s3.Credentials{AccessKey: "key", SecretKey: "secret"},
+			},
+		},
+		{
+			Host: "fallback.example.com",
+			Sources: []Source{
+				{Name: "cdn", FallbackOn: []string{FallbackError, Fallback5xx}},
+				{URL: "https://backup.example.com/images"},
+				{Name: "local", Dir: "/var/images", Symlinks: true},
+			},
+		},
+	}, origins)
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`[{"host": "a.com", "timeout": "5"}]`), 0600))
+	_, err = Load(filename)
+	require.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`[{"host": "a.com", "sources": [{"fallback_on": ["403"]}]}]`), 0600))
+	_, err = Load(filename)
+	require.EqualError(t, err, "unknown condition of fallback: 403")
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`[{"host": "a.com", "sources": [{"name": "cdn"}, {"name": "cdn"}]}]`), 0600))
+	_, err = Load(filename)
+	require.EqualError(t, err, "duplicate name of source: cdn")
+}
+
+func TestFallback(t *testing.T) {
+
+	all := Source{}
+	require.True(t, all.Fallback(FallbackError))
+	require.True(t, all.Fallback(Fallback5xx))
+	require.True(t, all.Fallback(FallbackNotFound))
+
+	none := Source{FallbackOn: []string{}}
+	require.False(t, none.Fallback(FallbackError))
+
+	some := Source{FallbackOn: []string{FallbackNotFound}}
+	require.False(t, some.Fallback(Fallback5xx))
+	require.True(t, some.Fallback(FallbackNotFound))
+}
@@ -0,0 +1,107 @@
+package peers
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ForwardedHeader marks requests sent from one peer to another,
+// the receiving peer must serve such request by itself.
+const ForwardedHeader = "X-Resizer-Forwarded"
+
+const defaultReplicas = 50
+
+// forwardedHeaders - headers of client request which are sent to peer
+var forwardedHeaders = []string{"Authorization", "If-None-Match", "If-Modified-Since"}
+
+// Pool of peers
+type Pool struct {
+	self   string
+	peers  []string
+	ring   *Ring
+	client *http.Client
+
+	mu sync.RWMutex
+}
+
+// NewPool returns new pool, self is the base URL of the current instance (e.g. "http://10.0.0.1:8000")
+func NewPool(self string) *Pool {
+	return &Pool{
+		self:   strings.TrimRight(self, "/"),
+		ring:   NewRing(defaultReplicas),
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Set updates the pool's list of peers (base URLs), the list should include self
+func (p *Pool) Set(peers ...string) {
+
+	list := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		list = append(list, strings.TrimRight(peer, "/"))
+	}
+
+	ring := NewRing(defaultReplicas, list...)
+
+	p.mu.Lock()
+	p.peers = list
+	p.ring = ring
+	p.mu.Unlock()
+}
+
+// Peers returns base URLs of all peers except self
+func (p *Pool) Peers() []string {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	list := make([]string, 0, len(p.peers))
+	for _, peer := range p.peers {
+		if peer != p.self {
+			list = append(list, peer)
+		}
+	}
+
+	return list
+}
+
+// Pick returns the base URL of the peer owning the key,
+// ok is false if the key is owned by the current instance.
+func (p *Pool) Pick(key string) (peer string, ok bool) {
+
+	p.mu.RLock()
+	peer = p.ring.Get(key)
+	p.mu.RUnlock()
+
+	if peer == "" || peer == p.self {
+		return "", false
+	}
+
+	return peer, true
+}
+
+// Forward sends the request to the peer and returns its response
+func (p *Pool) Forward(peer string, req *http.Request) (*http.Response, error) {
+
+	peerReq, err := http.NewRequest(req.Method, peer+req.URL.RequestURI(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	peerReq = peerReq.WithContext(req.Context())
+	peerReq.Header.Set(ForwardedHeader, p.self)
+	for _, name := range forwardedHeaders {
+		if value := req.Header.Get(name); value != "" {
+			peerReq.Header.Set(name, value)
+		}
+	}
+
+	return p.client.Do(peerReq)
+}
+
+// IsForwarded returns true if the request was received from another peer
+func IsForwarded(req *http.Request) bool {
+	return req.Header.Get(ForwardedHeader) != ""
+}
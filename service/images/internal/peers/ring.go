@@ -0,0 +1,57 @@
+package peers
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// Ring - consistent hash ring of peers
+type Ring struct {
+	replicas int
+	hashes   []uint32
+	owners   map[uint32]string
+}
+
+// NewRing returns new ring with the given number of virtual nodes per peer
+func NewRing(replicas int, peers ...string) *Ring {
+
+	r := &Ring{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+	}
+
+	for _, peer := range peers {
+		for i := 0; i < r.replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			r.hashes = append(r.hashes, hash)
+			r.owners[hash] = peer
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+
+	return r
+}
+
+// Empty returns true if ring has no peers
+func (r *Ring) Empty() bool {
+	return len(r.hashes) == 0
+}
+
+// Get returns the peer owning the key
+func (r *Ring) Get(key string) string {
+
+	if r.Empty() {
+		return ""
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0 // wrap around the ring
+	}
+
+	return r.owners[r.hashes[i]]
+}
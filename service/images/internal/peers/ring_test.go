@@ -0,0 +1,73 @@
+package peers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRing(t *testing.T) {
+
+	{
+		// test: empty ring
+		require.Equal(t, "", NewRing(10).Get("k1"))
+	}
+
+	{
+		// test: stable owners
+		r1 := NewRing(50, "http://a", "http://b", "http://c")
+		r2 := NewRing(50, "http://c", "http://a", "http://b")
+
+		counters := make(map[string]int)
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i)
+			require.Equal(t, r1.Get(key), r2.Get(key), key)
+			counters[r1.Get(key)]++
+		}
+
+		require.Len(t, counters, 3)
+		for peer, count := range counters {
+			require.True(t, count > 100, peer)
+		}
+	}
+
+	{
+		// test: adding of peer moves only part of keys
+		r1 := NewRing(50, "http://a", "http://b", "http://c")
+		r2 := NewRing(50, "http://a", "http://b", "http://c", "http://d")
+
+		var moved int
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i)
+			if owner := r2.Get(key); owner != r1.Get(key) {
+				require.Equal(t, "http://d", owner, key)
+				moved++
+			}
+		}
+
+		require.True(t, moved > 0 && moved < 500, moved)
+	}
+}
+
+func TestPoolPick(t *testing.T) {
+
+	p := NewPool("http://a/")
+	p.Set("http://a", "http://b/")
+
+	var self, other int
+	for i := 0; i < 100; i++ {
+		peer, ok := p.Pick(strconv.Itoa(i))
+		if ok {
+			require.Equal(t, "http://b", peer)
+			other++
+		} else {
+			self++
+		}
+	}
+
+	require.True(t, self > 0)
+	require.True(t, other > 0)
+
+	require.Equal(t, []string{"http://b"}, p.Peers())
+}
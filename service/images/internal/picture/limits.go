@@ -0,0 +1,125 @@
+package picture
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"strconv"
+)
+
+// Limits of source picture
+type Limits struct {
+	MaxPixels uint64 // max width*height, no limit if it's zero
+	MaxFrames int    // max number of frames of animated GIF, no limit if it's zero
+}
+
+// LimitError - the picture exceeds the limits
+type LimitError struct {
+	msg string
+}
+
+func (e *LimitError) Error() string {
+	return e.msg
+}
+
+var errInvalidGIF = errors.New("gif: invalid format")
+
+// Check returns error if the picture can't be decoded or exceeds the limits,
+// only the header of picture is decoded, so the check doesn't allocate memory for pixels.
+func Check(data []byte, limits Limits) error {
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if limits.MaxPixels > 0 && uint64(cfg.Width)*uint64(cfg.Height) > limits.MaxPixels {
+		return &LimitError{
+			msg: "picture " + strconv.Itoa(cfg.Width) + "x" + strconv.Itoa(cfg.Height) +
+				" exceeds the limit of " + strconv.FormatUint(limits.MaxPixels, 10) + " pixels",
+		}
+	}
+
+	if format == "gif" && limits.MaxFrames > 0 {
+		frames, err := countGIFFrames(data)
+		if err != nil {
+			return err
+		}
+
+		if frames > limits.MaxFrames {
+			return &LimitError{
+				msg: "picture has " + strconv.Itoa(frames) + " frames, the limit is " + strconv.Itoa(limits.MaxFrames),
+			}
+		}
+	}
+
+	return nil
+}
+
+// countGIFFrames returns number of frames of GIF, the frames are not decoded
+func countGIFFrames(data []byte) (int, error) {
+
+	const headerSize = 6 + 7 // signature, version and logical screen descriptor
+
+	if len(data) < headerSize {
+		return 0, errInvalidGIF
+	}
+
+	pos := headerSize
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += colorTableSize(flags) // global color table
+	}
+
+	var frames int
+	for pos < len(data) {
+		block := data[pos]
+		pos++
+
+		switch block {
+		case 0x21: // extension
+			if pos >= len(data) {
+				return 0, errInvalidGIF
+			}
+			pos++ // label
+
+		case 0x2C: // image descriptor
+			frames++
+
+			if pos+9 > len(data) {
+				return 0, errInvalidGIF
+			}
+			flags := data[pos+8]
+			pos += 9
+			if flags&0x80 != 0 {
+				pos += colorTableSize(flags) // local color table
+			}
+			pos++ // LZW minimum code size
+
+		case 0x3B: // trailer
+			return frames, nil
+
+		default:
+			return 0, errInvalidGIF
+		}
+
+		// data sub-blocks
+		for {
+			if pos >= len(data) {
+				return 0, errInvalidGIF
+			}
+
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+
+	// the trailer is missing, but the decoder accepts such pictures
+	return frames, nil
+}
+
+func colorTableSize(flags byte) int {
+	return 3 * (1 << ((flags & 0x07) + 1))
+}
@@ -0,0 +1,85 @@
+package picture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+
+	{
+		// test: the header of picture declares huge size
+		err := Check(helperPNGHeader(50000, 50000), Limits{MaxPixels: 100 * 1000 * 1000})
+		require.Error(t, err)
+		require.IsType(t, &LimitError{}, err)
+		require.Equal(t, "picture 50000x50000 exceeds the limit of 100000000 pixels", err.Error())
+
+		require.NoError(t, Check(helperPNGHeader(50000, 50000), Limits{}))
+	}
+
+	{
+		data := helperNewImage(t, 640, 480).Bytes()
+		require.NoError(t, Check(data, Limits{MaxPixels: 640 * 480}))
+		require.IsType(t, &LimitError{}, Check(data, Limits{MaxPixels: 640*480 - 1}))
+	}
+
+	{
+		// test: frames of GIF
+		data := helperNewGIF(t, 3)
+
+		frames, err := countGIFFrames(data)
+		require.NoError(t, err)
+		require.Equal(t, 3, frames)
+
+		require.NoError(t, Check(data, Limits{MaxFrames: 3}))
+		require.IsType(t, &LimitError{}, Check(data, Limits{MaxFrames: 2}))
+
+		_, err = countGIFFrames(data[:len(data)/2])
+		require.Error(t, err)
+	}
+
+	{
+		require.Error(t, Check([]byte("not a picture"), Limits{}))
+	}
+}
+
+// helperPNGHeader returns the PNG signature and the header chunk without pixels
+func helperPNGHeader(width, height uint32) []byte {
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 2 // RGB
+
+	buf := bytes.NewBuffer([]byte("\x89PNG\r\n\x1a\n"))
+	binary.Write(buf, binary.BigEndian, uint32(len(ihdr)))
+
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+
+	return buf.Bytes()
+}
+
+func helperNewGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 10, 10), palette.Plan9))
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	out := bytes.NewBuffer(nil)
+	require.NoError(t, gif.EncodeAll(out, anim))
+
+	return out.Bytes()
+}
@@ -2,10 +2,10 @@ package picture
 
 import (
 	"bufio"
+	"bytes"
 	"image"
-	"image/jpeg"
 	_ "image/gif"
-	_ "image/jpeg"
+	"image/jpeg"
 	_ "image/png"
 	"io"
 
@@ -17,12 +17,58 @@ import (
 // Resize picture. Attention! After using this is function need move to start of the 'in' reader
 func Resize(out io.Writer, in io.Reader, width, height uint) error {
 
+	img, err := Decode(in)
+	if err != nil {
+		return err
+	}
+
+	return Encode(out, img, width, height)
+}
+
+// Decode picture
+func Decode(in io.Reader) (image.Image, error) {
+
 	sr := bufio.NewReader(in)
 	img, _, err := image.Decode(sr)
+
+	return img, err
+}
+
+// OutputSize returns size of resized picture, zero width or height is calculated by the aspect ratio like Encode does,
+// only the header of picture is decoded
+func OutputSize(data []byte, width, height uint) (uint, uint, error) {
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
-		return err
+		return 0, 0, err
 	}
 
+	w, h := ScaledSize(cfg.Width, cfg.Height, width, height)
+
+	return w, h, nil
+}
+
+// ScaledSize returns size of resized picture by size of source, zero width or height preserves the aspect ratio
+func ScaledSize(srcWidth, srcHeight int, width, height uint) (uint, uint) {
+
+	switch {
+	case width == 0 && height == 0:
+		return uint(srcWidth), uint(srcHeight)
+	case width == 0:
+		// the same calculation as resize package does
+		scale := float64(srcHeight) / float64(height)
+		width = uint(0.7 + float64(srcWidth)/scale)
+	case height == 0:
+		scale := float64(srcWidth) / float64(width)
+		height = uint(0.7 + float64(srcHeight)/scale)
+	}
+
+	return width, height
+}
+
+// Encode resized picture in JPEG format, zero width or height preserves the aspect ratio
+func Encode(out io.Writer, img image.Image, width, height uint) error {
+
 	newImg := resize.Resize(width, height, img, resize.Lanczos3)
 
 	return jpeg.Encode(out, newImg, &jpeg.Options{Quality: 100})
@@ -40,6 +40,45 @@ func TestResize(t *testing.T) {
 	}
 }
 
+func TestOutputSize(t *testing.T) {
+
+	data := helperNewImage(t, 500, 1).Bytes()
+
+	for _, tc := range []struct {
+		Width, Height       uint
+		ExpWidth, ExpHeight uint
+	}{
+		{100, 100, 100, 100},
+		{0, 100, 50000, 100},
+		{100, 0, 100, 0}, // the same rounding as resize package
+		{0, 0, 500, 1},
+	} {
+		width, height, err := OutputSize(data, tc.Width, tc.Height)
+		require.NoError(t, err)
+		require.Equal(t, [2]uint{tc.ExpWidth, tc.ExpHeight}, [2]uint{width, height}, "%dx%d", tc.Width, tc.Height)
+	}
+
+	// the size is the same as the size of resized picture
+	for _, size := range [][2]uint{{0, 7}, {33, 0}} {
+		src := helperNewImage(t, 640, 480)
+		width, height, err := OutputSize(src.Bytes(), size[0], size[1])
+		require.NoError(t, err)
+
+		img, err := Decode(src)
+		require.NoError(t, err)
+
+		res := bytes.NewBuffer(nil)
+		require.NoError(t, Encode(res, img, size[0], size[1]))
+
+		cfg, err := jpeg.DecodeConfig(res)
+		require.NoError(t, err)
+		require.Equal(t, [2]uint{width, height}, [2]uint{uint(cfg.Width), uint(cfg.Height)})
+	}
+
+	_, _, err := OutputSize([]byte("invalid"), 10, 10)
+	require.Error(t, err)
+}
+
 func helperNewImage(t *testing.T, width, height int) *bytes.Buffer {
 	t.Helper()
 
@@ -75,3 +114,26 @@ func helperMD5(t *testing.T, src *bytes.Buffer) string {
 
 	return string(buf)
 }
+
+func TestEncode(t *testing.T) {
+
+	img, err := Decode(helperNewImage(t, 640, 480))
+	require.NoError(t, err)
+
+	{
+		// test: the same result as resize
+		res := bytes.NewBuffer(nil)
+		require.NoError(t, Encode(res, img, 200, 200))
+		require.Equal(t, "eaee52177384ef106128b029adddf64e", helperMD5(t, res))
+	}
+
+	{
+		// test: preserve aspect ratio
+		res := bytes.NewBuffer(nil)
+		require.NoError(t, Encode(res, img, 320, 0))
+
+		newImg, err := jpeg.Decode(res)
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 320, 240), newImg.Bounds())
+	}
+}
@@ -0,0 +1,134 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config of bucket of S3-compatible storage
+type Config struct {
+	Endpoint string // base URL of S3 API, e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Region   string // region of the bucket, us-east-1 if it's empty
+	Bucket   string
+	Prefix   string // prefix of keys of objects, e.g. "originals/"
+
+	Credentials
+}
+
+// Bucket - client of bucket, the objects are addressed by path (endpoint/bucket/key)
+type Bucket struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+}
+
+// New returns client of bucket
+func New(cfg Config, client *http.Client) *Bucket {
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	return &Bucket{
+		cfg:    cfg,
+		client: client,
+		now:    time.Now,
+	}
+}
+
+// Host returns host of the endpoint
+func (b *Bucket) Host() string {
+
+	u, err := url.Parse(b.cfg.Endpoint)
+	if err != nil {
+		return b.cfg.Endpoint
+	}
+
+	return u.Host
+}
+
+// Get sends request of object, the key is prefixed by the prefix of bucket.
+// The additional headers (e.g. conditional) aren't signed.
+func (b *Bucket) Get(ctx context.Context, key string, header http.Header) (*http.Response, error) {
+
+	req, err := b.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	Sign(req, b.cfg.Credentials, b.cfg.Region, "s3", EmptyPayload, b.now())
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	return b.client.Do(req)
+}
+
+// Put uploads object, the key is prefixed by the prefix of bucket
+func (b *Bucket) Put(ctx context.Context, key string, data []byte, contentType string) error {
+
+	req, err := b.newRequest(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	sum := sha256.Sum256(data)
+	Sign(req, b.cfg.Credentials, b.cfg.Region, "s3", hex.EncodeToString(sum[:]), b.now())
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return &Error{Status: res.Status}
+	}
+
+	return nil
+}
+
+func (b *Bucket) newRequest(ctx context.Context, method, key string, data []byte) (*http.Request, error) {
+
+	u, err := url.Parse(strings.TrimSuffix(b.cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path += "/" + b.cfg.Bucket + "/" + b.cfg.Prefix + strings.TrimPrefix(key, "/")
+	u.RawPath = escape(u.Path, false) // the path is sent as it's signed
+
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+// Error - unexpected response of S3 API
+type Error struct {
+	Status string
+}
+
+func (e *Error) Error() string {
+	return "invalid response of storage:" + e.Status
+}
@@ -0,0 +1,71 @@
+package s3_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/khevse/image-resizer/service/images/internal/s3"
+	"github.com/khevse/image-resizer/service/images/internal/s3/s3test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket(t *testing.T) {
+
+	cred := s3.Credentials{AccessKey: "key", SecretKey: "secret"}
+
+	fake := s3test.NewFake(cred)
+	svr := httptest.NewServer(fake)
+	defer svr.Close()
+
+	bucket := s3.New(s3.Config{Endpoint: svr.URL + "/", Bucket: "images", Prefix: "originals/", Credentials: cred}, http.DefaultClient)
+
+	ctx := context.Background()
+
+	{
+		// test: put and get object with special characters in key
+		require.NoError(t, bucket.Put(ctx, "/photos/a b+(1).jpg", []byte("data"), "image/jpeg"))
+
+		data, contentType, ok := fake.Get("images", "originals/photos/a b+(1).jpg")
+		require.True(t, ok)
+		require.Equal(t, "data", string(data))
+		require.Equal(t, "image/jpeg", contentType)
+
+		res, err := bucket.Get(ctx, "photos/a b+(1).jpg", nil)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "data", string(body))
+
+		// the conditional headers aren't signed
+		res, err = bucket.Get(ctx, "photos/a b+(1).jpg", http.Header{"If-None-Match": {res.Header.Get("ETag")}})
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusNotModified, res.StatusCode)
+	}
+
+	{
+		// test: missing object
+		res, err := bucket.Get(ctx, "photos/missing.jpg", nil)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	}
+
+	{
+		// test: invalid credentials
+		other := s3.New(s3.Config{Endpoint: svr.URL, Bucket: "images", Credentials: s3.Credentials{AccessKey: "key", SecretKey: "other"}}, http.DefaultClient)
+
+		res, err := other.Get(ctx, "originals/photos/a b+(1).jpg", nil)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		err = other.Put(ctx, "a.jpg", []byte("data"), "image/jpeg")
+		require.Equal(t, &s3.Error{Status: "403 Forbidden"}, err)
+	}
+}
@@ -0,0 +1,174 @@
+// Package s3test provides in-memory S3 API for tests
+package s3test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/s3"
+)
+
+// Fake - in-memory S3 API for tests, the requests must be signed by the known credentials
+type Fake struct {
+	keys map[string]string
+
+	objects  map[string]fakeObject // "bucket/key" -> object
+	requests int
+	mu       sync.Mutex
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+// NewFake returns fake S3 API
+func NewFake(cred s3.Credentials) *Fake {
+	return &Fake{
+		keys:    map[string]string{cred.AccessKey: cred.SecretKey},
+		objects: make(map[string]fakeObject),
+	}
+}
+
+// Put stores object without request
+func (f *Fake) Put(bucket, key string, data []byte, contentType string) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.objects[bucket+"/"+key] = fakeObject{
+		data:        append([]byte(nil), data...),
+		contentType: contentType,
+		modified:    time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// Get returns object and its content type
+func (f *Fake) Get(bucket, key string) ([]byte, string, bool) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	obj, ok := f.objects[bucket+"/"+key]
+	return obj.data, obj.contentType, ok
+}
+
+// Requests returns number of received requests
+func (f *Fake) Requests() int {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	f.mu.Lock()
+	f.requests++
+	f.mu.Unlock()
+
+	if err := verify(req, f.keys); err != nil {
+		fakeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, "/")
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		f.mu.Lock()
+		obj, ok := f.objects[name]
+		f.mu.Unlock()
+
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		sum := md5.Sum(obj.data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		w.Header().Set("Content-Type", obj.contentType)
+
+		// the conditional requests are handled by ServeContent
+		http.ServeContent(w, req, "", obj.modified, bytes.NewReader(obj.data))
+
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			fakeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+
+		sum := sha256.Sum256(data)
+		if req.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			fakeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+			return
+		}
+
+		f.mu.Lock()
+		f.objects[name] = fakeObject{
+			data:        data,
+			contentType: req.Header.Get("Content-Type"),
+			modified:    time.Now().UTC().Truncate(time.Second),
+		}
+		f.mu.Unlock()
+
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// verify checks signature of request, the request is signed again by the secret key found by access key
+// with the date, region and service of the request, so the signatures must be the same
+func verify(req *http.Request, keys map[string]string) error {
+
+	const prefix = "AWS4-HMAC-SHA256 Credential="
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return errors.New("invalid authorization")
+	}
+
+	// access key/date/region/service/aws4_request
+	credential := strings.Split(strings.SplitN(strings.TrimPrefix(auth, prefix), ",", 2)[0], "/")
+	if len(credential) != 5 {
+		return errors.New("invalid credential")
+	}
+
+	secret, ok := keys[credential[0]]
+	if !ok {
+		return errors.New("unknown access key")
+	}
+
+	now, err := time.Parse("20060102T150405Z", req.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return errors.New("invalid date")
+	}
+
+	signed := req.Clone(req.Context())
+	cred := s3.Credentials{AccessKey: credential[0], SecretKey: secret}
+	s3.Sign(signed, cred, credential[2], credential[3], req.Header.Get("X-Amz-Content-Sha256"), now)
+
+	if !hmac.Equal([]byte(signed.Header.Get("Authorization")), []byte(auth)) {
+		return errors.New("signature does not match")
+	}
+
+	return nil
+}
+
+func fakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte("<Error><Code>" + code + "</Code></Error>"))
+}
@@ -0,0 +1,166 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+
+	// EmptyPayload - hash of empty body
+	EmptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Credentials - access keys of S3 API
+type Credentials struct {
+	AccessKey string
+	SecretKey string
+}
+
+// Sign adds AWS Signature Version 4 to the request (headers X-Amz-Date and Authorization),
+// the payload hash is hex encoded SHA-256 of the body. The headers Host, Content-Type, Content-MD5, Range
+// and X-Amz-* are signed, the others (e.g. conditional headers) may be changed by proxies.
+func Sign(req *http.Request, cred Credentials, region, service, payloadHash string, now time.Time) {
+
+	req.Header.Set("X-Amz-Date", now.UTC().Format(timeFormat))
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	headers := signedHeaders(req)
+	scope := now.UTC().Format(dateFormat) + "/" + region + "/" + service + "/aws4_request"
+
+	req.Header.Set("Authorization", algorithm+
+		" Credential="+cred.AccessKey+"/"+scope+
+		", SignedHeaders="+strings.Join(headers, ";")+
+		", Signature="+signature(req, cred.SecretKey, scope, headers, payloadHash))
+}
+
+// signature returns hex encoded signature of the request, the scope is date/region/service/aws4_request
+func signature(req *http.Request, secret, scope string, headers []string, payloadHash string) string {
+
+	canonical := canonicalRequest(req, headers, payloadHash)
+	sum := sha256.Sum256([]byte(canonical))
+
+	stringToSign := algorithm + "\n" +
+		req.Header.Get("X-Amz-Date") + "\n" +
+		scope + "\n" +
+		hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func canonicalRequest(req *http.Request, headers []string, payloadHash string) string {
+
+	var b strings.Builder
+
+	uri := req.URL.Path
+	if uri == "" {
+		uri = "/"
+	}
+
+	b.WriteString(req.Method + "\n")
+	b.WriteString(escape(uri, false) + "\n")
+	b.WriteString(canonicalQuery(req) + "\n")
+
+	for _, name := range headers {
+		b.WriteString(name + ":" + headerValue(req, name) + "\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(strings.Join(headers, ";") + "\n")
+	b.WriteString(payloadHash)
+
+	return b.String()
+}
+
+func canonicalQuery(req *http.Request) string {
+
+	var pairs []string
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			pairs = append(pairs, escape(name, true)+"="+escape(v, true))
+		}
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+// signedHeaders returns sorted lower case names of signed headers of the request
+func signedHeaders(req *http.Request) []string {
+
+	headers := []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		switch {
+		case name == "content-type", name == "content-md5", name == "range", strings.HasPrefix(name, "x-amz-"):
+			headers = append(headers, name)
+		}
+	}
+
+	sort.Strings(headers)
+
+	return headers
+}
+
+// headerValue returns trimmed values of header separated by comma, the sequential spaces are replaced by one
+func headerValue(req *http.Request, name string) string {
+
+	if name == "host" {
+		if req.Host != "" {
+			return req.Host
+		}
+		return req.URL.Host
+	}
+
+	var values []string
+	for _, v := range req.Header[http.CanonicalHeaderKey(name)] {
+		values = append(values, strings.Join(strings.Fields(v), " "))
+	}
+
+	return strings.Join(values, ",")
+}
+
+// escape encodes string by rules of AWS: all bytes except unreserved characters are encoded,
+// the slashes are kept if it isn't a query.
+func escape(s string, query bool) string {
+
+	const hexChars = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !query:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexChars[c>>4])
+			b.WriteByte(hexChars[c&15])
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
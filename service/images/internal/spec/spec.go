@@ -0,0 +1,99 @@
+package spec
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Version of the serialization format, must be changed on any change of String
+const Version = "v1"
+
+// Spec - transform specification of image
+type Spec struct {
+	URL    string // canonical URL of source image, it's used for keys, signatures and matching of origins
+	RawURL string // URL of source image sent by client, it's loaded from resource
+	Width  uint   // width of resized image, greater than zero
+	Height uint   // height of resized image, greater than zero
+}
+
+// New returns spec with canonical URL
+func New(rawURL string, width, height uint) Spec {
+	return Spec{
+		URL:    CanonicalURL(rawURL),
+		RawURL: rawURL,
+		Width:  width,
+		Height: height,
+	}
+}
+
+// Parse returns spec from query parameters of resize request
+func Parse(q url.Values) (Spec, error) {
+
+	reqURL := q.Get("url")
+	if reqURL == "" {
+		return Spec{}, errors.New("invalid resource URL")
+	}
+
+	width, err := parseSize("width", q.Get("width"))
+	if err != nil {
+		return Spec{}, err
+	}
+
+	height, err := parseSize("height", q.Get("height"))
+	if err != nil {
+		return Spec{}, err
+	}
+
+	return New(reqURL, width, height), nil
+}
+
+// String returns deterministic serialization of spec, the URL is escaped and is the last field,
+// so different specs can't have the same serialization. The raw URL isn't serialized.
+func (s Spec) String() string {
+	return Version +
+		":w=" + strconv.FormatUint(uint64(s.Width), 10) +
+		"&h=" + strconv.FormatUint(uint64(s.Height), 10) +
+		"&url=" + url.QueryEscape(s.URL)
+}
+
+// CanonicalURL returns URL in canonical form, so the same resource gets the same key
+func CanonicalURL(rawURL string) string {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = u.Hostname()
+	}
+
+	if u.Host != "" && u.Path == "" {
+		u.Path = "/"
+	}
+
+	// the query is sorted only if it's parsed without loss (e.g. "a=1;b=2" isn't)
+	if q, err := url.ParseQuery(u.RawQuery); err == nil {
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String()
+}
+
+func parseSize(name, src string) (uint, error) {
+
+	size, err := strconv.Atoi(src)
+	if err != nil {
+		return 0, errors.New("invalid property " + name + ": " + err.Error())
+	} else if size <= 0 {
+		return 0, errors.New("invalid property " + name)
+	}
+
+	return uint(size), nil
+}
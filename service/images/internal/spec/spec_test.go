@@ -0,0 +1,101 @@
+package spec
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+
+	{
+		s, err := Parse(url.Values{"url": {"HTTP://Example.com/a.jpg"}, "width": {"010"}, "height": {"+5"}})
+		require.NoError(t, err)
+		require.Equal(t, Spec{URL: "http://example.com/a.jpg", RawURL: "HTTP://Example.com/a.jpg", Width: 10, Height: 5}, s)
+		require.Equal(t, "v1:w=10&h=5&url=http%3A%2F%2Fexample.com%2Fa.jpg", s.String())
+	}
+
+	for _, testinfo := range []struct {
+		Query  url.Values
+		ErrMsg string
+	}{
+		{url.Values{"width": {"1"}, "height": {"1"}}, "invalid resource URL"},
+		{url.Values{"url": {"-"}, "height": {"1"}}, `invalid property width: strconv.Atoi: parsing "": invalid syntax`},
+		{url.Values{"url": {"-"}, "width": {"0"}, "height": {"1"}}, "invalid property width"},
+		{url.Values{"url": {"-"}, "width": {"1"}, "height": {"1.2"}}, `invalid property height: strconv.Atoi: parsing "1.2": invalid syntax`},
+		{url.Values{"url": {"-"}, "width": {"1"}, "height": {"-1"}}, "invalid property height"},
+	} {
+		_, err := Parse(testinfo.Query)
+		require.EqualError(t, err, testinfo.ErrMsg, testinfo.Query.Encode())
+	}
+}
+
+func TestStringCollisions(t *testing.T) {
+
+	{
+		// test: the sizes don't collide
+		s1 := New("http://example.com/a.jpg", 1, 23)
+		s2 := New("http://example.com/a.jpg", 12, 3)
+		require.NotEqual(t, s1.String(), s2.String())
+	}
+
+	{
+		// test: the URL can't imitate other fields
+		s1 := New("http://example.com/a.jpg?x=1&h=2", 1, 1)
+		s2 := New("http://example.com/a.jpg?x=1", 1, 1)
+		require.NotEqual(t, s1.String(), s2.String())
+	}
+
+	// property: different specs have different serializations
+	f := func(u1, u2 string, w1, h1, w2, h2 uint16) bool {
+		s1 := Spec{URL: u1, Width: uint(w1), Height: uint(h1)}
+		s2 := Spec{URL: u2, Width: uint(w2), Height: uint(h2)}
+
+		return (s1 == s2) == (s1.String() == s2.String())
+	}
+	require.NoError(t, quick.Check(f, &quick.Config{MaxCount: 10000}))
+
+	// property: the same sizes in any decimal form give the same spec
+	g := func(w, h uint16, wZeros, hZeros uint8) bool {
+		w, h = w%1000+1, h%1000+1
+
+		s1, err := Parse(url.Values{
+			"url":    {"http://example.com/a.jpg"},
+			"width":  {zeros(wZeros%4) + strconv.Itoa(int(w))},
+			"height": {zeros(hZeros%4) + strconv.Itoa(int(h))},
+		})
+		if err != nil {
+			return false
+		}
+
+		return s1 == New("http://example.com/a.jpg", uint(w), uint(h))
+	}
+	require.NoError(t, quick.Check(g, nil))
+}
+
+func TestCanonicalURL(t *testing.T) {
+
+	for src, exp := range map[string]string{
+		"-":                                   "-",
+		"http://example.com":                  "http://example.com/",
+		"HTTP://Example.COM:80/a/b?z=1&a=2#f": "http://example.com/a/b?a=2&z=1",
+		"https://example.com:443/a":           "https://example.com/a",
+		"https://example.com:8443/a":          "https://example.com:8443/a",
+		"http://example.com/a?a=1;b=2":        "http://example.com/a?a=1;b=2",
+	} {
+		require.Equal(t, exp, CanonicalURL(src), src)
+	}
+}
+
+func zeros(n uint8) string {
+
+	var s string
+	for i := uint8(0); i < n; i++ {
+		s += "0"
+	}
+
+	return s
+}
@@ -0,0 +1,140 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeLimits(t *testing.T) {
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/bomb":
+			// the header of PNG declares 50000x50000 pixels
+			ihdr := make([]byte, 13)
+			binary.BigEndian.PutUint32(ihdr[0:], 50000)
+			binary.BigEndian.PutUint32(ihdr[4:], 50000)
+			ihdr[8], ihdr[9] = 8, 2 // bit depth, RGB
+
+			chunk := append([]byte("IHDR"), ihdr...)
+			buf := bytes.NewBuffer([]byte("\x89PNG\r\n\x1a\n"))
+			binary.Write(buf, binary.BigEndian, uint32(len(ihdr)))
+			buf.Write(chunk)
+			binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+
+			w.Write(buf.Bytes())
+		default:
+			buf := helperNewImage(t, 100, 100)
+			_, err := io.Copy(w, buf)
+			require.NoError(t, err)
+		}
+	}))
+	defer source.Close()
+
+	cfg := helperConfig()
+	cfg.MaxOutputWidth = 1000
+	cfg.MaxOutputHeight = 500
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	testCases := []struct {
+		Name      string
+		Path      string
+		Query     []string
+		ExpStatus int
+	}{
+		{"valid", "/resize", []string{"url", source.URL, "width", "1000", "height", "500"}, http.StatusOK},
+		{"width", "/resize", []string{"url", source.URL, "width", "100000", "height", "10"}, http.StatusUnprocessableEntity},
+		{"height", "/resize", []string{"url", source.URL, "width", "10", "height", "501"}, http.StatusUnprocessableEntity},
+		{"batch", "/resize/batch", []string{"url", source.URL, "sizes", "10x10,1001x1"}, http.StatusUnprocessableEntity},
+		{"batch zero width", "/resize/batch", []string{"url", source.URL, "sizes", "10x10,0x100"}, http.StatusBadRequest},
+		{"batch zero height", "/resize/batch", []string{"url", source.URL, "sizes", "10x10,100x0"}, http.StatusBadRequest},
+		{"pixels", "/resize", []string{"url", source.URL + "/bomb", "width", "10", "height", "10"}, http.StatusRequestEntityTooLarge},
+		{"batch pixels", "/resize/batch", []string{"url", source.URL + "/bomb", "sizes", "10x10"}, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			u, err := url.Parse(svr.URL + tc.Path)
+			require.NoError(t, err)
+			helperSetQuery(u, tc.Query...)
+
+			res, err := http.Get(u.String())
+			require.NoError(t, err)
+			require.NoError(t, res.Body.Close())
+			require.Equal(t, tc.ExpStatus, res.StatusCode)
+		})
+	}
+}
+
+func TestResizeSourceSize(t *testing.T) {
+
+	const maxSourceSize = 1000
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data := bytes.Repeat([]byte{0x01}, maxSourceSize+1)
+
+		switch req.URL.Path {
+		case "/content-length":
+			w.Header().Set("Content-Length", "1000000")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush() // the body isn't sent
+		case "/chunked":
+			w.Write(data[:maxSourceSize/2])
+			w.(http.Flusher).Flush()
+			w.Write(data[maxSourceSize/2:])
+		default:
+			buf := helperNewImage(t, 10, 10)
+			_, err := io.Copy(w, buf)
+			require.NoError(t, err)
+		}
+	}))
+	defer source.Close()
+
+	cfg := helperConfig()
+	cfg.MaxSourceSize = maxSourceSize
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	testCases := []struct {
+		Path      string
+		ExpStatus int
+		ExpMsg    string
+	}{
+		{"/", http.StatusOK, ""},
+		{"/content-length", http.StatusRequestEntityTooLarge, "source image is too large: 1000000 bytes, the limit is 1000 bytes\n"},
+		{"/chunked", http.StatusRequestEntityTooLarge, "source image is too large: 1001 bytes, the limit is 1000 bytes\n"},
+	}
+
+	for _, tc := range testCases {
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL+tc.Path, "width", "10", "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, tc.ExpStatus, res.StatusCode, tc.Path)
+
+		if tc.ExpMsg != "" {
+			require.Equal(t, tc.ExpMsg, helperGetStringFromBody(t, res), tc.Path)
+		} else {
+			require.NoError(t, res.Body.Close())
+		}
+	}
+}
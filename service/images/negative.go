@@ -0,0 +1,48 @@
+package images
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+)
+
+// NegativeCacheHeader - header of responses with errors from negative cache
+const NegativeCacheHeader = "X-Negative-Cache"
+
+// checkNegative returns error from negative cache if the source image failed recently
+func (h *Handler) checkNegative(sourceURL string) error {
+
+	data, meta, expired, ok := h.negativeCache.LookupStale(cache.NewKey(sourceURL))
+	if !ok {
+		return nil
+	}
+
+	log.Println("send error from negative cache")
+	atomic.AddInt64(&h.stats.NegativeHits, 1)
+
+	// round up, so the header never contains zero ttl
+	ttl := (expired.Sub(h.negativeCache.Now()) + time.Second - 1) / time.Second
+
+	err := newHTTPError(meta.Status, string(data))
+	err.failure = meta.Failure
+	err.header = http.Header{
+		NegativeCacheHeader: {"HIT; status=" + strconv.Itoa(meta.Status) + "; ttl=" + strconv.FormatInt(int64(ttl), 10)},
+	}
+
+	return err
+}
+
+// addNegative stores error of the source image to negative cache
+func (h *Handler) addNegative(sourceURL string, err error) {
+
+	e, ok := err.(*httpError)
+	if !ok || !e.negative || h.negativeLifetime == 0 {
+		return
+	}
+
+	h.negativeCache.Set(cache.NewKey(sourceURL), []byte(e.msg), cache.Meta{Source: sourceURL, Status: e.code, Failure: e.failure})
+}
@@ -0,0 +1,128 @@
+package images
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeNegativeCache(t *testing.T) {
+
+	var (
+		sourceRequests = make(map[string]int)
+		mu             sync.Mutex
+	)
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		sourceRequests[req.URL.Path]++
+		mu.Unlock()
+
+		switch req.URL.Path {
+		case "/missing":
+			http.NotFound(w, req)
+		case "/unavailable":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.Write([]byte("not an image"))
+		}
+	}))
+	defer source.Close()
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cfg := helperConfig()
+	cfg.Clock = clk
+	cfg.AdminToken = testAdminToken
+	cfg.FetchTimeout = 50 * time.Millisecond
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	resize := func(path string, expStatus int, expMsg, expHeader string) {
+		t.Helper()
+
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL+path, "width", "10", "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, expStatus, res.StatusCode)
+		require.Equal(t, expHeader, res.Header.Get(NegativeCacheHeader))
+		require.Equal(t, expMsg+"\n", helperGetStringFromBody(t, res))
+	}
+
+	requests := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return sourceRequests[path]
+	}
+
+	{
+		// test: not found
+		resize("/missing", http.StatusNotFound, "resource not found:404 Not Found", "")
+		resize("/missing", http.StatusNotFound, "resource not found:404 Not Found", "HIT; status=404; ttl=30")
+
+		clk.Advance(20 * time.Second)
+		resize("/missing", http.StatusNotFound, "resource not found:404 Not Found", "HIT; status=404; ttl=10")
+		require.Equal(t, 1, requests("/missing"))
+
+		clk.Advance(10 * time.Second)
+		resize("/missing", http.StatusNotFound, "resource not found:404 Not Found", "")
+		require.Equal(t, 2, requests("/missing"))
+	}
+
+	{
+		// test: decode failure
+		resize("/bad", http.StatusInternalServerError, "internal server error:image: unknown format", "")
+		resize("/bad", http.StatusInternalServerError, "internal server error:image: unknown format", "HIT; status=500; ttl=30")
+		require.Equal(t, 1, requests("/bad"))
+	}
+
+	{
+		// test: temporary failure is not cached
+		resize("/unavailable", http.StatusBadGateway, "invalid response of resource:503 Service Unavailable", "")
+		resize("/unavailable", http.StatusBadGateway, "invalid response of resource:503 Service Unavailable", "")
+		require.Equal(t, 2, requests("/unavailable"))
+	}
+
+	{
+		// test: timeout
+		for _, expHeader := range []string{"", "HIT; status=504; ttl=30"} {
+			u, err := url.Parse(svr.URL + "/resize")
+			require.NoError(t, err)
+			helperSetQuery(u, "url", source.URL+"/slow", "width", "10", "height", "10")
+
+			res, err := http.Get(u.String())
+			require.NoError(t, err)
+			require.NoError(t, res.Body.Close())
+			require.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+			require.Equal(t, expHeader, res.Header.Get(NegativeCacheHeader))
+		}
+		require.Equal(t, 1, requests("/slow"))
+	}
+
+	{
+		// test: purge
+		var res AdminPurgeResult
+		helperAdmin(t, http.MethodPost, svr.URL+"/admin/purge?url="+url.QueryEscape(source.URL+"/missing"), &res)
+		require.Equal(t, 1, res.Purged)
+
+		resize("/missing", http.StatusNotFound, "resource not found:404 Not Found", "")
+		require.Equal(t, 3, requests("/missing"))
+	}
+
+	require.Equal(t, int64(4), h.Stats().NegativeHits)
+}
@@ -0,0 +1,103 @@
+package images
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/khevse/image-resizer/service/images/internal/origin"
+)
+
+// Origin - allowed resource of source images with its settings (timeout, max size, headers and credentials)
+type Origin = origin.Origin
+
+// OriginSource - source of images in the fallback chain of origin
+type OriginSource = origin.Source
+
+// conditions of fallback to the next source of origin
+const (
+	FallbackError    = origin.FallbackError
+	Fallback5xx      = origin.Fallback5xx
+	FallbackNotFound = origin.FallbackNotFound
+)
+
+// LoadOrigins reads list of allowed origins from JSON file
+func LoadOrigins(filename string) ([]Origin, error) {
+	return origin.Load(filename)
+}
+
+// checkOrigin returns origin of source image or error if the origin isn't allowed,
+// the origin is nil if all origins are allowed or the image is loaded from file.
+func (h *Handler) checkOrigin(reqURL string) (*Origin, error) {
+
+	if len(h.origins) == 0 {
+		return nil, nil
+	}
+
+	u, err := url.Parse(reqURL)
+	if err != nil {
+		return nil, newHTTPError(http.StatusBadRequest, "invalid resource URL:"+err.Error())
+	}
+
+	if u.Scheme == fileScheme && h.sources[fileScheme] != nil {
+		return nil, nil // the files are limited by root directory
+	}
+
+	o, ok := h.origins.Match(u)
+	if !ok {
+		return nil, newHTTPError(http.StatusForbidden, "origin of resource is not allowed")
+	}
+
+	return o, nil
+}
+
+// setOriginHeader sets headers and credentials of origin to the request to resource
+func setOriginHeader(req *http.Request, o *Origin) {
+
+	for name, values := range o.Header {
+		req.Header[name] = values
+	}
+
+	if o.Username != "" || o.Password != "" {
+		req.SetBasicAuth(o.Username, o.Password)
+	}
+}
+
+// originRedirects returns check of redirects which denies the redirects outside the allowed origins,
+// the redirects inside the host of source of origin (e.g. CDN) are allowed. It's nil if all origins are allowed.
+// The headers and credentials of origins aren't sent to other hosts, the ones of target origin are set instead.
+func originRedirects(origins origin.List) func(req *http.Request, via []*http.Request) error {
+
+	if len(origins) == 0 {
+		return nil
+	}
+
+	return func(req *http.Request, via []*http.Request) error {
+
+		first := via[0].URL
+
+		target, ok := origins.Match(req.URL)
+		if !ok {
+			if _, ok := origins.Match(first); !ok && strings.EqualFold(first.Host, req.URL.Host) {
+				return nil
+			}
+			return errors.New("origin of resource is not allowed")
+		}
+
+		if strings.EqualFold(first.Host, req.URL.Host) {
+			return nil
+		}
+
+		for i := range origins {
+			for name := range origins[i].Header {
+				req.Header.Del(name)
+			}
+		}
+		req.Header.Del("Authorization")
+
+		setOriginHeader(req, target)
+
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+package images
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeOrigins(t *testing.T) {
+
+	var sourceRequests int32
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+
+		switch req.URL.Path {
+		case "/public/slow.jpg":
+			time.Sleep(500 * time.Millisecond)
+		case "/public/redirect/public":
+			http.Redirect(w, req, "/public/a.jpg", http.StatusFound)
+			return
+		case "/public/redirect/private":
+			http.Redirect(w, req, "/private/a.jpg", http.StatusFound)
+			return
+		case "/public/redirect/other":
+			_, port, err := net.SplitHostPort(req.Host)
+			require.NoError(t, err)
+			http.Redirect(w, req, "http://localhost:"+port+"/public/a.jpg", http.StatusFound)
+			return
+		}
+
+		username, password, _ := req.BasicAuth()
+		if username != "user" || password != "secret" || req.Header.Get("X-Api-Key") != "key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	sourceURL, err := url.Parse(source.URL)
+	require.NoError(t, err)
+
+	origin := Origin{
+		Host:       sourceURL.Host,
+		PathPrefix: "/public",
+		Header:     http.Header{"X-Api-Key": {"key"}},
+		Username:   "user",
+		Password:   "secret",
+	}
+
+	slowOrigin := origin
+	slowOrigin.PathPrefix = "/public/slow.jpg"
+	slowOrigin.Timeout = 50 * time.Millisecond
+
+	largeOrigin := origin
+	largeOrigin.PathPrefix = "/public/large.jpg"
+	largeOrigin.MaxSourceSize = 10
+
+	cfg := helperConfig()
+	cfg.Origins = []Origin{slowOrigin, largeOrigin, origin}
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	testCases := []struct {
+		Name        string
+		URL         string
+		ExpStatus   int
+		ExpRequests int32
+	}{
+		{"allowed", source.URL + "/public/a.jpg", http.StatusOK, 1},
+		{"other path", source.URL + "/private/a.jpg", http.StatusForbidden, 0},
+		{"path traversal", source.URL + "/public/../private/a.jpg", http.StatusForbidden, 0},
+		{"other host", "http://localhost:" + sourceURL.Port() + "/public/a.jpg", http.StatusForbidden, 0},
+		{"timeout", source.URL + "/public/slow.jpg", http.StatusGatewayTimeout, 1},
+		{"max source size", source.URL + "/public/large.jpg", http.StatusRequestEntityTooLarge, 1},
+		{"redirect", source.URL + "/public/redirect/public", http.StatusOK, 2},
+		{"redirect to other path", source.URL + "/public/redirect/private", http.StatusForbidden, 1},
+		{"redirect to other host", source.URL + "/public/redirect/other", http.StatusForbidden, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			atomic.StoreInt32(&sourceRequests, 0)
+
+			u, err := url.Parse(svr.URL + "/resize")
+			require.NoError(t, err)
+			helperSetQuery(u, "url", tc.URL, "width", "10", "height", "10")
+
+			res, err := http.Get(u.String())
+			require.NoError(t, err)
+			require.NoError(t, res.Body.Close())
+			require.Equal(t, tc.ExpStatus, res.StatusCode)
+			require.Equal(t, tc.ExpRequests, atomic.LoadInt32(&sourceRequests))
+		})
+	}
+
+	{
+		// test: batch request
+		u, err := url.Parse(svr.URL + "/resize/batch")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL+"/private/a.jpg", "sizes", "10x10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+	}
+}
+
+func TestResizeOriginsRedirect(t *testing.T) {
+
+	var received http.Header // headers of request to the second origin
+
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received = req.Header.Clone()
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer second.Close()
+
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		username, password, _ := req.BasicAuth()
+		if username != "user" || password != "secret" || req.Header.Get("X-Api-Key") != "first" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		http.Redirect(w, req, second.URL+req.URL.Path, http.StatusFound)
+	}))
+	defer first.Close()
+
+	firstURL, err := url.Parse(first.URL)
+	require.NoError(t, err)
+
+	secondURL, err := url.Parse(second.URL)
+	require.NoError(t, err)
+
+	cfg := helperConfig()
+	cfg.Origins = []Origin{
+		{
+			Host:     firstURL.Host,
+			Header:   http.Header{"X-Api-Key": {"first"}, "X-First-Secret": {"secret"}},
+			Username: "user",
+			Password: "secret",
+		},
+		{
+			Host:   secondURL.Host,
+			Header: http.Header{"X-Api-Key": {"second"}},
+		},
+	}
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", first.URL+"/a.jpg", "width", "10", "height", "10")
+
+	res, err := http.Get(u.String())
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// the headers and credentials of the first origin aren't sent to the second one
+	require.Equal(t, "second", received.Get("X-Api-Key"))
+	require.Empty(t, received.Get("X-First-Secret"))
+	require.Empty(t, received.Get("Authorization"))
+}
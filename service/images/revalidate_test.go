@@ -0,0 +1,114 @@
+package images
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeRevalidate(t *testing.T) {
+
+	var (
+		sourceRequests    int32
+		sourceNotModified int32
+		sourceVersion     atomic.Value
+	)
+
+	sourceVersion.Store(`"v1"`)
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+
+		etag := sourceVersion.Load().(string)
+		w.Header().Set("ETag", etag)
+
+		if req.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&sourceNotModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cfg := helperConfig()
+	cfg.CacheLifetime = time.Minute
+	cfg.SourceCacheLifetime = time.Minute
+	cfg.Clock = clk
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	resize := func(width string) {
+		t.Helper()
+
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL, "width", width, "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+	}
+
+	check := func(expRequests, expNotModified, expLoads int) {
+		t.Helper()
+
+		require.Equal(t, int32(expRequests), atomic.LoadInt32(&sourceRequests))
+		require.Equal(t, int32(expNotModified), atomic.LoadInt32(&sourceNotModified))
+		require.Equal(t, int64(expLoads), h.Stats().Loads)
+		require.Equal(t, int64(expNotModified), h.Stats().SourceRevalidations)
+	}
+
+	resize("10")
+	resize("20")
+	check(1, 0, 2)
+
+	{
+		// test: not modified source extends all resized images
+		clk.Advance(2 * time.Minute)
+
+		resize("10")
+		check(2, 1, 2)
+
+		resize("20")
+		check(2, 1, 2)
+	}
+
+	{
+		// test: the source image is removed from cache, the validators of resized image are used
+		h.sourceCache.Clear()
+		clk.Advance(2 * time.Minute)
+
+		resize("10")
+		check(3, 2, 2)
+	}
+
+	{
+		// test: modified source
+		sourceVersion.Store(`"v2"`)
+		clk.Advance(2 * time.Minute)
+
+		resize("10")
+		check(4, 2, 3)
+
+		resize("20")
+		check(4, 2, 4)
+	}
+}
@@ -0,0 +1,209 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/spec"
+)
+
+// Query parameters of signed URL
+const (
+	ParamSignature = "sig"
+	ParamKeyID     = "kid"
+	ParamExpires   = "expires"
+)
+
+// Errors of verification
+var (
+	ErrMissing = errors.New("signature is missing")
+	ErrInvalid = errors.New("signature is invalid")
+	ErrExpired = errors.New("signed URL is expired")
+)
+
+// Key - shared key of signature
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Size of resized image
+type Size struct {
+	Width  uint
+	Height uint
+}
+
+// ResizeMessage returns signed message of resize request, the tags of request are signed too
+func ResizeMessage(sourceURL string, width, height uint, tags ...string) string {
+	return spec.New(sourceURL, width, height).String() + tagsMessage(tags)
+}
+
+// BatchMessage returns signed message of batch request, the sizes are in order of request
+func BatchMessage(sourceURL string, sizes []Size, tags ...string) string {
+
+	list := make([]string, 0, len(sizes))
+	for _, size := range sizes {
+		list = append(list, ResizeMessage(sourceURL, size.Width, size.Height))
+	}
+
+	return strings.Join(list, "\n") + tagsMessage(tags)
+}
+
+// tagsMessage returns signed line of sorted unique tags, it's empty if there are no tags,
+// so the messages of requests without tags are the same as before
+func tagsMessage(tags []string) string {
+
+	if len(tags) == 0 {
+		return ""
+	}
+
+	return "\ntags=" + strings.Join(uniqueTags(tags), ",")
+}
+
+// uniqueTags returns sorted list of unique tags
+func uniqueTags(tags []string) []string {
+
+	unique := append([]string(nil), tags...)
+	sort.Strings(unique)
+
+	n := 0
+	for i, tag := range unique {
+		if i == 0 || tag != unique[n-1] {
+			unique[n] = tag
+			n++
+		}
+	}
+
+	return unique[:n]
+}
+
+// Sign returns HMAC-SHA256 signature of message and expiration time (unix seconds, zero if the URL doesn't expire)
+func Sign(secret []byte, message string, expires int64) string {
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+	mac.Write([]byte("\n"))
+	if expires > 0 {
+		mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Keys - active keys by their IDs, several keys allow to rotate them
+type Keys map[string][]byte
+
+// Verify checks signature of the message in query of request
+func (k Keys) Verify(q url.Values, message string, now time.Time) error {
+
+	sig := q.Get(ParamSignature)
+	if sig == "" {
+		return ErrMissing
+	}
+
+	var expires int64
+	if value := q.Get(ParamExpires); value != "" {
+		var err error
+		if expires, err = strconv.ParseInt(value, 10, 64); err != nil || expires <= 0 {
+			return ErrInvalid
+		}
+	}
+
+	var secrets [][]byte
+	if kid := q.Get(ParamKeyID); kid != "" {
+		secret, ok := k[kid]
+		if !ok {
+			return ErrInvalid
+		}
+		secrets = append(secrets, secret)
+	} else {
+		// the key isn't specified, so all keys are checked
+		for _, secret := range k {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	valid := false
+	for _, secret := range secrets {
+		// constant time comparison
+		if hmac.Equal([]byte(sig), []byte(Sign(secret, message, expires))) {
+			valid = true
+		}
+	}
+
+	if !valid {
+		return ErrInvalid
+	}
+
+	if expires > 0 && !now.Before(time.Unix(expires, 0)) {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// ResizeURL returns signed URL of resize request with optional tags, base is URL of resize handler
+// (e.g. "https://resizer/resize"), the URL doesn't expire if expires is zero.
+func ResizeURL(base string, key Key, sourceURL string, width, height uint, expires time.Time, tags ...string) (string, error) {
+
+	q := url.Values{}
+	q.Set("url", sourceURL)
+	q.Set("width", strconv.FormatUint(uint64(width), 10))
+	q.Set("height", strconv.FormatUint(uint64(height), 10))
+	setTags(q, tags)
+
+	return signURL(base, key, q, ResizeMessage(sourceURL, width, height, tags...), expires)
+}
+
+// BatchURL returns signed URL of batch request with optional tags, base is URL of batch handler
+// (e.g. "https://resizer/resize/batch"), the URL doesn't expire if expires is zero.
+func BatchURL(base string, key Key, sourceURL string, sizes []Size, expires time.Time, tags ...string) (string, error) {
+
+	list := make([]string, 0, len(sizes))
+	for _, size := range sizes {
+		list = append(list, strconv.FormatUint(uint64(size.Width), 10)+"x"+strconv.FormatUint(uint64(size.Height), 10))
+	}
+
+	q := url.Values{}
+	q.Set("url", sourceURL)
+	q.Set("sizes", strings.Join(list, ","))
+	setTags(q, tags)
+
+	return signURL(base, key, q, BatchMessage(sourceURL, sizes, tags...), expires)
+}
+
+func setTags(q url.Values, tags []string) {
+	if len(tags) > 0 {
+		q.Set("tags", strings.Join(tags, ","))
+	}
+}
+
+func signURL(base string, key Key, q url.Values, message string, expires time.Time) (string, error) {
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	var unix int64
+	if !expires.IsZero() {
+		unix = expires.Unix()
+		q.Set(ParamExpires, strconv.FormatInt(unix, 10))
+	}
+
+	if key.ID != "" {
+		q.Set(ParamKeyID, key.ID)
+	}
+
+	q.Set(ParamSignature, Sign(key.Secret, message, unix))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,106 @@
+package signature
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	oldKey := Key{ID: "k1", Secret: []byte("old secret")}
+	newKey := Key{ID: "k2", Secret: []byte("new secret")}
+	keys := Keys{oldKey.ID: oldKey.Secret, newKey.ID: newKey.Secret}
+
+	const sourceURL = "http://example.com/a.jpg"
+	message := ResizeMessage(sourceURL, 10, 20)
+
+	verify := func(rawURL string, message string) error {
+		t.Helper()
+
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+
+		return keys.Verify(u.Query(), message, now)
+	}
+
+	{
+		// test: keys rotation
+		for _, key := range []Key{oldKey, newKey} {
+			signed, err := ResizeURL("http://resizer/resize", key, sourceURL, 10, 20, time.Time{})
+			require.NoError(t, err)
+			require.NoError(t, verify(signed, message))
+		}
+
+		signed, err := ResizeURL("http://resizer/resize", Key{ID: "k3", Secret: []byte("unknown")}, sourceURL, 10, 20, time.Time{})
+		require.NoError(t, err)
+		require.Equal(t, ErrInvalid, verify(signed, message))
+	}
+
+	{
+		// test: without key ID
+		signed, err := ResizeURL("http://resizer/resize", Key{Secret: newKey.Secret}, sourceURL, 10, 20, time.Time{})
+		require.NoError(t, err)
+		require.NoError(t, verify(signed, message))
+	}
+
+	{
+		// test: other transform
+		signed, err := ResizeURL("http://resizer/resize", newKey, sourceURL, 10, 20, time.Time{})
+		require.NoError(t, err)
+		require.Equal(t, ErrInvalid, verify(signed, ResizeMessage(sourceURL, 100, 20)))
+	}
+
+	{
+		// test: the same canonical spec
+		signed, err := ResizeURL("http://resizer/resize", newKey, "HTTP://EXAMPLE.COM:80/a.jpg", 10, 20, time.Time{})
+		require.NoError(t, err)
+		require.NoError(t, verify(signed, message))
+	}
+
+	{
+		// test: expiration
+		signed, err := ResizeURL("http://resizer/resize", newKey, sourceURL, 10, 20, now.Add(time.Minute))
+		require.NoError(t, err)
+		require.NoError(t, verify(signed, message))
+
+		signed, err = ResizeURL("http://resizer/resize", newKey, sourceURL, 10, 20, now)
+		require.NoError(t, err)
+		require.Equal(t, ErrExpired, verify(signed, message))
+
+		// the expiration time is signed
+		u, err := url.Parse(signed)
+		require.NoError(t, err)
+		q := u.Query()
+		q.Set(ParamExpires, "1893456000")
+		require.Equal(t, ErrInvalid, keys.Verify(q, message, now))
+	}
+
+	{
+		// test: missing signature
+		require.Equal(t, ErrMissing, verify("http://resizer/resize?url=a&width=10&height=20", message))
+	}
+
+	{
+		// test: batch
+		sizes := []Size{{10, 20}, {30, 0}}
+		signed, err := BatchURL("http://resizer/resize/batch", newKey, sourceURL, sizes, time.Time{})
+		require.NoError(t, err)
+		require.NoError(t, verify(signed, BatchMessage(sourceURL, sizes)))
+		require.Equal(t, ErrInvalid, verify(signed, BatchMessage(sourceURL, sizes[:1])))
+	}
+
+	{
+		// test: tags
+		signed, err := ResizeURL("http://resizer/resize", newKey, sourceURL, 10, 20, time.Time{}, "t2", "t1")
+		require.NoError(t, err)
+		require.Contains(t, signed, "tags=t2%2Ct1")
+		require.NoError(t, verify(signed, ResizeMessage(sourceURL, 10, 20, "t1", "t2", "t1")))
+		require.Equal(t, ErrInvalid, verify(signed, message))
+		require.Equal(t, ErrInvalid, verify(signed, ResizeMessage(sourceURL, 10, 20, "t1")))
+	}
+}
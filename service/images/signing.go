@@ -0,0 +1,40 @@
+package images
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/khevse/image-resizer/service/images/signature"
+)
+
+// checkSignature verifies signature of the request message if the signing keys are set
+func (h *Handler) checkSignature(q url.Values, message string) error {
+
+	if len(h.signingKeys) == 0 {
+		return nil
+	}
+
+	if err := h.signingKeys.Verify(q, message, h.cache.Now()); err != nil {
+		return newHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	return nil
+}
+
+// peerKey returns the signing key of requests to peers, ok is false if the signing keys aren't set.
+// The peers share the keys, so the first key by ID is used.
+func (h *Handler) peerKey() (key signature.Key, ok bool) {
+
+	if len(h.signingKeys) == 0 {
+		return signature.Key{}, false
+	}
+
+	ids := make([]string, 0, len(h.signingKeys))
+	for id := range h.signingKeys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return signature.Key{ID: ids[0], Secret: h.signingKeys[ids[0]]}, true
+}
@@ -0,0 +1,118 @@
+package images
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/khevse/image-resizer/service/images/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeSigned(t *testing.T) {
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := signature.Key{ID: "k1", Secret: []byte("secret")}
+
+	cfg := helperConfig()
+	cfg.Clock = clock.NewFake(now)
+	cfg.SigningKeys = map[string][]byte{key.ID: key.Secret}
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	get := func(rawURL string, expStatus int) {
+		t.Helper()
+
+		res, err := http.Get(rawURL)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, expStatus, res.StatusCode)
+	}
+
+	{
+		signed, err := signature.ResizeURL(svr.URL+"/resize", key, source.URL, 10, 10, now.Add(time.Minute))
+		require.NoError(t, err)
+		get(signed, http.StatusOK)
+
+		// test: other size
+		u, err := url.Parse(signed)
+		require.NoError(t, err)
+		q := u.Query()
+		q.Set("width", "20")
+		u.RawQuery = q.Encode()
+		get(u.String(), http.StatusForbidden)
+
+		// test: without signature
+		q.Del(signature.ParamSignature)
+		u.RawQuery = q.Encode()
+		get(u.String(), http.StatusForbidden)
+	}
+
+	{
+		// test: tags are signed
+		signed, err := signature.ResizeURL(svr.URL+"/resize", key, source.URL, 10, 10, time.Time{}, "b", "a")
+		require.NoError(t, err)
+		get(signed, http.StatusOK)
+
+		u, err := url.Parse(signed)
+		require.NoError(t, err)
+		q := u.Query()
+		q.Set("tags", "a,b,c")
+		u.RawQuery = q.Encode()
+		get(u.String(), http.StatusForbidden)
+
+		// test: order and duplicates of tags don't matter
+		q.Set("tags", "a,b,a")
+		u.RawQuery = q.Encode()
+		get(u.String(), http.StatusOK)
+
+		q.Del("tags")
+		u.RawQuery = q.Encode()
+		get(u.String(), http.StatusForbidden)
+	}
+
+	{
+		// test: expired
+		signed, err := signature.ResizeURL(svr.URL+"/resize", key, source.URL, 10, 10, now.Add(-time.Second))
+		require.NoError(t, err)
+		get(signed, http.StatusForbidden)
+	}
+
+	{
+		// test: batch
+		sizes := []signature.Size{{Width: 10, Height: 10}, {Width: 20, Height: 20}}
+		signed, err := signature.BatchURL(svr.URL+"/resize/batch", key, source.URL, sizes, time.Time{})
+		require.NoError(t, err)
+		get(signed, http.StatusOK)
+
+		signed, err = signature.BatchURL(svr.URL+"/resize/batch", signature.Key{Secret: []byte("other")}, source.URL, sizes, time.Time{})
+		require.NoError(t, err)
+		get(signed, http.StatusForbidden)
+
+		signed, err = signature.BatchURL(svr.URL+"/resize/batch", key, source.URL, sizes, time.Time{}, "user-1")
+		require.NoError(t, err)
+		get(signed, http.StatusOK)
+
+		u, err := url.Parse(signed)
+		require.NoError(t, err)
+		q := u.Query()
+		q.Set("tags", "user-2")
+		u.RawQuery = q.Encode()
+		get(u.String(), http.StatusForbidden)
+	}
+}
@@ -0,0 +1,72 @@
+package images
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// SaveSnapshot writes content of caches to the file
+func (h *Handler) SaveSnapshot(path string) error {
+
+	// write to temporary file and rename it, so the previous snapshot is never damaged
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(f)
+
+	if err := h.cache.Save(w); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := h.sourceCache.Save(w); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// LoadSnapshot reads content of caches from the file written by SaveSnapshot, expired entries are skipped
+func (h *Handler) LoadSnapshot(path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	loaded, err := h.cache.Load(r)
+	if err != nil {
+		return err
+	}
+
+	sourceLoaded, err := h.sourceCache.Load(r)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("loaded from snapshot: %d images, %d source images", loaded, sourceLoaded)
+
+	return nil
+}
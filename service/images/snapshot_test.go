@@ -0,0 +1,75 @@
+package images
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	var sourceRequests int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cache.snapshot")
+
+	{
+		// source instance
+		h := helperNew()
+		defer h.Close()
+
+		svr := httptest.NewServer(h.Mux())
+		defer svr.Close()
+
+		helperResize(t, svr.URL, source.URL, "10", "10")
+		helperResize(t, svr.URL, source.URL, "20", "20")
+
+		require.NoError(t, h.SaveSnapshot(path))
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&sourceRequests))
+
+	{
+		// restarted instance
+		h := helperNew()
+		defer h.Close()
+
+		require.NoError(t, h.LoadSnapshot(path))
+
+		svr := httptest.NewServer(h.Mux())
+		defer svr.Close()
+
+		helperResize(t, svr.URL, source.URL, "10", "10")
+		helperResize(t, svr.URL, source.URL, "30", "30")
+
+		require.Equal(t, Stats{Requests: 2, CacheHits: 1, Loads: 1, SourceCacheHits: 1}, h.Stats())
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&sourceRequests))
+
+	{
+		// test: not existing file
+		h := helperNew()
+		defer h.Close()
+
+		require.True(t, os.IsNotExist(h.LoadSnapshot(filepath.Join(dir, "unknown"))))
+	}
+}
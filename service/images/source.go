@@ -0,0 +1,69 @@
+package images
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/spec"
+)
+
+// source of source images
+type source interface {
+	// load returns source image by URL sent by client, the result is not modified
+	// if the image matches the validators of cached image.
+	load(ctx context.Context, reqURL string, validators cache.Meta) (result, error)
+}
+
+// fileScheme - scheme of URLs of the file source
+const fileScheme = "file"
+
+// checkScheme returns parsed URL of source image if its scheme is supported:
+// http, https or scheme of enabled source (e.g. file)
+func (h *Handler) checkScheme(reqURL string) (*url.URL, error) {
+
+	u, err := url.Parse(reqURL)
+	if err != nil {
+		return nil, newHTTPError(400, "invalid resource URL:"+err.Error())
+	}
+
+	if _, ok := h.sources[u.Scheme]; ok {
+		return u, nil
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, newHTTPError(400, "invalid resource URL:unsupported scheme "+strconv.Quote(u.Scheme))
+	}
+
+	if u.Host == "" {
+		return nil, newHTTPError(400, "invalid resource URL:no host")
+	}
+
+	return u, nil
+}
+
+// fetch source image from the source selected by scheme of URL or by its origin,
+// the HTTP source is used by default.
+func (h *Handler) fetch(ctx context.Context, reqURL string, validators cache.Meta) (result, error) {
+
+	u, err := h.checkScheme(reqURL)
+	if err != nil {
+		return result{}, err
+	}
+
+	if src, ok := h.sources[u.Scheme]; ok {
+		return src.load(ctx, reqURL, validators)
+	}
+
+	o, err := h.checkOrigin(spec.CanonicalURL(reqURL))
+	if err != nil {
+		return result{}, err
+	}
+
+	if src, ok := h.originSources[o]; ok {
+		return src.load(ctx, reqURL, validators)
+	}
+
+	return httpSource{h: h, origin: o}.load(ctx, reqURL, validators)
+}
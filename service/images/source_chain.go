@@ -0,0 +1,134 @@
+package images
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/origin"
+	"github.com/khevse/image-resizer/service/images/internal/s3"
+)
+
+// SourceHeader - header of responses with name of source which served the source image,
+// it's sent for origins with several sources
+const SourceHeader = "X-Resizer-Source"
+
+// chainSource tries sources of origin in turn until one of them loads the image
+type chainSource []chainItem
+
+type chainItem struct {
+	name   string
+	src    source
+	config *origin.Source
+}
+
+func (c chainSource) load(ctx context.Context, reqURL string, validators cache.Meta) (result, error) {
+
+	var (
+		src result
+		err error
+	)
+
+	for i, item := range c {
+		src, err = item.src.load(ctx, reqURL, sourceValidators(validators, item.name))
+		if err == nil {
+			src.meta.SourceName = item.name
+			return src, nil
+		}
+
+		condition := fallbackCondition(err)
+		if i == len(c)-1 || condition == "" || !item.config.Fallback(condition) {
+			break
+		}
+
+		log.Println("source", item.name, "failed, try the next one:", err)
+	}
+
+	return result{}, err
+}
+
+// sourceValidators returns validators of cached image for the source,
+// the validators produced by other source are removed, because they are meaningless for the source
+func sourceValidators(validators cache.Meta, name string) cache.Meta {
+
+	if validators.SourceName != name {
+		validators.ETag = ""
+		validators.LastModified = ""
+	}
+
+	return validators
+}
+
+// fallbackCondition returns condition of fallback to the next source by error of source,
+// the condition is empty if the error isn't caused by the source (e.g. the request is canceled).
+func fallbackCondition(err error) string {
+
+	e, ok := err.(*httpError)
+	switch {
+	case !ok:
+		return ""
+	case e.code == http.StatusNotFound:
+		return origin.FallbackNotFound
+	case e.code == http.StatusBadGateway && e.failure:
+		return origin.Fallback5xx
+	case e.failure || e.code == http.StatusServiceUnavailable:
+		return origin.FallbackError
+	}
+
+	return ""
+}
+
+// newOriginSource returns source of origin, it's nil if the image URLs of origin are requested directly
+func (h *Handler) newOriginSource(o *Origin, storageClient *http.Client) source {
+
+	if len(o.Sources) == 0 {
+		if o.S3 != nil {
+			return s3Source{h: h, origin: o, bucket: s3.New(*o.S3, storageClient)}
+		}
+		return nil
+	}
+
+	chain := make(chainSource, 0, len(o.Sources))
+	names := make(map[string]bool, len(o.Sources))
+	for i := range o.Sources {
+		cfg := &o.Sources[i]
+		item := chainItem{name: cfg.Name, config: cfg}
+
+		switch {
+		case cfg.S3 != nil:
+			item.src = s3Source{h: h, origin: o, bucket: s3.New(*cfg.S3, storageClient)}
+			if item.name == "" {
+				item.name = "s3"
+			}
+
+		case cfg.Dir != "":
+			root, err := filepath.Abs(cfg.Dir)
+			if err != nil {
+				root = cfg.Dir // it's checked on each request
+			}
+			item.src = fileSource{h: h, origin: o, root: root, symlinks: cfg.Symlinks}
+			if item.name == "" {
+				item.name = "file"
+			}
+
+		default:
+			item.src = httpSource{h: h, origin: o, base: cfg.URL}
+			if item.name == "" {
+				item.name = "http"
+			}
+		}
+
+		if names[item.name] {
+			// the names of sources of the same kind are distinguished by position in chain
+			item.name += "-" + strconv.Itoa(i+1)
+		}
+		names[item.name] = true
+
+		chain = append(chain, item)
+	}
+
+	return chain
+}
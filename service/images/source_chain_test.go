@@ -0,0 +1,236 @@
+package images
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/khevse/image-resizer/service/images/internal/s3"
+	"github.com/khevse/image-resizer/service/images/internal/s3/s3test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeFallback(t *testing.T) {
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/static/photos/a.jpg":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case "/static/photos/moved.jpg":
+			http.Redirect(w, req, "/static/photos/b.jpg", http.StatusFound)
+		case "/static/photos/b.jpg":
+			buf := helperNewImage(t, 100, 100)
+			_, err := io.Copy(w, buf)
+			require.NoError(t, err)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer cdn.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cred := s3.Credentials{AccessKey: "key", SecretKey: "secret"}
+
+	storage := s3test.NewFake(cred)
+	storageSvr := httptest.NewServer(storage)
+	defer storageSvr.Close()
+
+	image := helperNewImage(t, 100, 100).Bytes()
+	storage.Put("backup", "photos/a.jpg", image, "image/jpeg")
+
+	dir, err := ioutil.TempDir("", "fallback")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "photos"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "photos", "a.jpg"), image, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "photos", "c.jpg"), image, 0644))
+
+	cfg := helperConfig()
+	cfg.NegativeCacheLifetime = 0
+	cfg.Origins = []Origin{
+		{
+			Host: "images.example.com",
+			Sources: []OriginSource{
+				{Name: "cdn", URL: cdn.URL + "/static/"},
+				{Name: "backup", S3: &S3Config{Endpoint: storageSvr.URL, Bucket: "backup", Credentials: cred}},
+				{Dir: dir},
+			},
+		},
+		{
+			Host: "strict.example.com",
+			Sources: []OriginSource{
+				{Name: "cdn", URL: cdn.URL + "/static", FallbackOn: []string{Fallback5xx}},
+				{Dir: dir},
+			},
+		},
+		{
+			Host: "down.example.com",
+			Sources: []OriginSource{
+				{URL: down.URL},
+				{Dir: dir},
+			},
+		},
+	}
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	resize := func(sourceURL string, expStatus int, expSource string) {
+		t.Helper()
+
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", sourceURL, "width", "10", "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, expStatus, res.StatusCode, sourceURL)
+		require.Equal(t, expSource, res.Header.Get(SourceHeader), sourceURL)
+	}
+
+	resize("http://images.example.com/photos/b.jpg", http.StatusOK, "cdn")
+	resize("http://images.example.com/photos/b.jpg", http.StatusOK, "cdn") // from cache
+	resize("http://images.example.com/photos/moved.jpg", http.StatusOK, "cdn")
+	resize("http://images.example.com/photos/a.jpg", http.StatusOK, "backup")
+	resize("http://images.example.com/photos/c.jpg", http.StatusOK, "file")
+	resize("http://images.example.com/photos/missing.jpg", http.StatusNotFound, "")
+	require.Equal(t, 3, storage.Requests())
+
+	// 404 of the first source isn't a condition of fallback
+	resize("http://strict.example.com/photos/c.jpg", http.StatusNotFound, "")
+	resize("http://strict.example.com/photos/a.jpg", http.StatusOK, "file")
+
+	// connection error
+	resize("http://down.example.com/photos/c.jpg", http.StatusOK, "file")
+}
+
+func TestResizeFallbackValidators(t *testing.T) {
+
+	var (
+		cdnFailing     int32 = 1
+		cdnConditional int32 // number of conditional requests to CDN
+	)
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") != "" {
+			atomic.AddInt32(&cdnConditional, 1)
+		}
+
+		if req.URL.Path == "/photos/a.jpg" && atomic.LoadInt32(&cdnFailing) > 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("ETag", `"cdn"`)
+		if req.Header.Get("If-None-Match") == `"cdn"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer cdn.Close()
+
+	cred := s3.Credentials{AccessKey: "key", SecretKey: "secret"}
+
+	storage := s3test.NewFake(cred)
+	storageSvr := httptest.NewServer(storage)
+	defer storageSvr.Close()
+
+	storage.Put("backup", "photos/a.jpg", helperNewImage(t, 100, 100).Bytes(), "image/jpeg")
+
+	clk := clock.NewFake(time.Now())
+
+	cfg := helperConfig()
+	cfg.Clock = clk
+	cfg.CacheLifetime = time.Minute
+	cfg.SourceCacheLifetime = time.Minute
+	cfg.Origins = []Origin{
+		{
+			Host: "images.example.com",
+			Sources: []OriginSource{
+				{Name: "cdn", URL: cdn.URL},
+				{Name: "backup", S3: &S3Config{Endpoint: storageSvr.URL, Bucket: "backup", Credentials: cred}},
+			},
+		},
+	}
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	resize := func(sourceURL string, expSource string) {
+		t.Helper()
+
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", sourceURL, "width", "10", "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusOK, res.StatusCode, sourceURL)
+		require.Equal(t, expSource, res.Header.Get(SourceHeader), sourceURL)
+	}
+
+	resize("http://images.example.com/photos/a.jpg", "backup")
+	resize("http://images.example.com/photos/b.jpg", "cdn")
+
+	// test: the validators are sent only to the source which produced them
+	atomic.StoreInt32(&cdnFailing, 0)
+	clk.Advance(2 * time.Minute)
+
+	resize("http://images.example.com/photos/a.jpg", "cdn")
+	require.Equal(t, int32(0), atomic.LoadInt32(&cdnConditional))
+
+	resize("http://images.example.com/photos/b.jpg", "cdn")
+	require.Equal(t, int32(1), atomic.LoadInt32(&cdnConditional))
+	require.Equal(t, int64(1), h.Stats().SourceRevalidations)
+}
+
+func TestOriginSourceNames(t *testing.T) {
+
+	h := helperNew()
+	defer h.Close()
+
+	o := &Origin{
+		Host: "images.example.com",
+		Sources: []OriginSource{
+			{URL: "http://cdn.example.com"},
+			{URL: "http://backup.example.com"},
+			{Name: "http-3", Dir: "/var/images"},
+			{Dir: "/var/backup"},
+			{},
+		},
+	}
+
+	chain, ok := h.newOriginSource(o, nil).(chainSource)
+	require.True(t, ok)
+
+	names := make([]string, 0, len(chain))
+	for _, item := range chain {
+		names = append(names, item.name)
+	}
+
+	// the default names are unique in chain
+	require.Equal(t, []string{"http", "http-2", "http-3", "file", "http-5"}, names)
+}
@@ -0,0 +1,198 @@
+package images
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+)
+
+// fileSource loads source images from local directory by URLs file:///path/in/root
+// or by paths of image URLs of origin, the validators of the images are based on their modification time and size.
+type fileSource struct {
+	h        *Handler
+	origin   *Origin // nil for file URLs
+	root     string
+	symlinks bool // symlinks are followed if their targets are inside the root
+}
+
+func (s fileSource) load(ctx context.Context, reqURL string, validators cache.Meta) (result, error) {
+
+	u, err := url.Parse(reqURL)
+	if err != nil {
+		return result{}, newHTTPError(400, "invalid resource URL:"+err.Error())
+	}
+
+	if u.Scheme == fileScheme && (u.Opaque != "" || (u.Host != "" && u.Host != "localhost")) {
+		return result{}, newHTTPError(400, "invalid resource URL:file URL must be absolute and local")
+	}
+
+	log.Println("send from file")
+	atomic.AddInt64(&s.h.stats.SourceLoads, 1)
+
+	filename, err := s.resolve(u.Path)
+	if err != nil {
+		return result{}, err
+	}
+
+	// the file is checked before opening, because opening of named pipe or device may block
+	info, err := os.Stat(filename)
+	if err != nil {
+		return result{}, fileError(u.Path, err)
+	}
+
+	if info.IsDir() {
+		e := newHTTPError(http.StatusNotFound, "resource not found:"+u.Path+" is a directory")
+		e.negative = true
+		return result{}, e
+	}
+
+	if !info.Mode().IsRegular() {
+		return result{}, newHTTPError(http.StatusForbidden, "forbidden resource:"+u.Path+" is not a regular file")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return result{}, fileError(u.Path, err)
+	}
+
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Println("ERROR:", err)
+		}
+	}()
+
+	if info, err = f.Stat(); err != nil {
+		return result{}, fileError(u.Path, err)
+	}
+
+	meta := cache.Meta{
+		Source:       reqURL,
+		ETag:         fileETag(info),
+		LastModified: info.ModTime().UTC().Format(http.TimeFormat),
+		Modified:     info.ModTime(),
+	}
+
+	if isNotModified(meta, validators) {
+		validators.Lifetime = 0 // the lifetime of cached image is kept
+		return result{meta: validators, notModified: true}, nil
+	}
+
+	maxSourceSize := s.h.originMaxSourceSize(s.origin)
+	if maxSourceSize > 0 && info.Size() > maxSourceSize {
+		return result{}, sourceTooLarge(info.Size(), maxSourceSize)
+	}
+
+	var body io.Reader = f
+	if maxSourceSize > 0 {
+		// the file may grow after Stat
+		body = io.LimitReader(f, maxSourceSize+1)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return result{}, fileError(u.Path, err)
+	}
+
+	if maxSourceSize > 0 && int64(len(data)) > maxSourceSize {
+		return result{}, sourceTooLarge(int64(len(data)), maxSourceSize)
+	}
+
+	if now := s.h.cache.Now(); meta.Modified.After(now) {
+		meta.Modified = now
+	}
+
+	return result{data: data, meta: meta}, nil
+}
+
+// resolve returns name of file by path of URL, the file can't be outside the root
+// and the symlinks in the path are denied or they must point inside the root.
+func (s fileSource) resolve(urlPath string) (string, error) {
+
+	root, err := filepath.EvalSymlinks(s.root)
+	if err != nil {
+		log.Println("ERROR: invalid root of file source:", err)
+		e := newHTTPError(http.StatusInternalServerError, "file source is unavailable")
+		e.failure = true
+		return "", e
+	}
+
+	// the cleaned absolute path can't contain ".." elements
+	filename := filepath.Join(root, filepath.FromSlash(path.Clean("/"+urlPath)))
+
+	resolved, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return "", fileError(urlPath, err)
+	}
+
+	if resolved == filename {
+		return filename, nil
+	}
+
+	if !s.symlinks {
+		return "", newHTTPError(http.StatusForbidden, "forbidden resource:symbolic links are denied")
+	}
+
+	if !isInside(root, resolved) {
+		return "", newHTTPError(http.StatusForbidden, "forbidden resource:symbolic link points outside the root")
+	}
+
+	return resolved, nil
+}
+
+// isInside returns true if the name is the directory or it's inside the directory
+func isInside(dir, name string) bool {
+	rel, err := filepath.Rel(dir, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// fileETag returns weak entity tag of file by its modification time and size
+func fileETag(info os.FileInfo) string {
+	return `W/"` + strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36) + `"`
+}
+
+// isNotModified returns true if the validators of cached image match the current ones,
+// the entity tag is preferred to the modification time
+func isNotModified(current, validators cache.Meta) bool {
+
+	if validators.ETag != "" {
+		return validators.ETag == current.ETag
+	}
+
+	since, err := http.ParseTime(validators.LastModified)
+	if err != nil {
+		return false
+	}
+
+	// the header has precision of seconds
+	return !current.Modified.Truncate(time.Second).After(since)
+}
+
+// fileError returns error of file system, the name of file on server isn't exposed
+func fileError(urlPath string, err error) *httpError {
+
+	switch {
+	case os.IsNotExist(err):
+		e := newHTTPError(http.StatusNotFound, "resource not found:"+urlPath)
+		e.negative = true
+		return e
+	case os.IsPermission(err):
+		return newHTTPError(http.StatusForbidden, "forbidden resource:"+urlPath)
+	}
+
+	log.Println("ERROR: failed to read file:", err)
+	e := newHTTPError(http.StatusInternalServerError, "failed to read file:"+urlPath)
+	e.failure = true
+	return e
+}
@@ -0,0 +1,146 @@
+package images
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "files")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "photos"), 0755))
+
+	image := helperNewImage(t, 100, 100).Bytes()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "photos", "a.jpg"), image, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secret.jpg"), image, 0644))
+	require.NoError(t, os.Symlink(filepath.Join(root, "photos", "a.jpg"), filepath.Join(root, "inside.jpg")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret.jpg"), filepath.Join(root, "outside.jpg")))
+
+	clk := clock.NewFake(time.Now())
+
+	newServer := func(symlinks bool) (*Handler, *httptest.Server) {
+		cfg := helperConfig()
+		cfg.Clock = clk
+		cfg.CacheLifetime = time.Minute
+		cfg.SourceCacheLifetime = time.Minute
+		cfg.NegativeCacheLifetime = 0
+		cfg.FileRoot = root
+		cfg.FileSymlinks = symlinks
+
+		h := NewWithConfig(cfg)
+		return h, httptest.NewServer(h.Mux())
+	}
+
+	resize := func(svrURL, sourceURL string, expStatus int) *http.Response {
+		t.Helper()
+
+		u, err := url.Parse(svrURL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", sourceURL, "width", "10", "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, expStatus, res.StatusCode, sourceURL)
+
+		return res
+	}
+
+	{
+		// test: symlinks are denied
+		h, svr := newServer(false)
+		defer h.Close()
+		defer svr.Close()
+
+		resize(svr.URL, "file:///photos/a.jpg", http.StatusOK)
+		resize(svr.URL, "file://localhost/photos/a.jpg", http.StatusOK)
+		resize(svr.URL, "file:///photos/../../secret.jpg", http.StatusNotFound)
+		resize(svr.URL, "file:///%2e%2e/secret.jpg", http.StatusNotFound)
+		resize(svr.URL, "file:///photos/missing.jpg", http.StatusNotFound)
+		resize(svr.URL, "file:///photos", http.StatusNotFound)
+		resize(svr.URL, "file://example.com/photos/a.jpg", http.StatusBadRequest)
+		resize(svr.URL, "file:///inside.jpg", http.StatusForbidden)
+		resize(svr.URL, "file:///outside.jpg", http.StatusForbidden)
+	}
+
+	{
+		// test: symlinks inside the root are followed
+		h, svr := newServer(true)
+		defer h.Close()
+		defer svr.Close()
+
+		resize(svr.URL, "file:///inside.jpg", http.StatusOK)
+		resize(svr.URL, "file:///outside.jpg", http.StatusForbidden)
+	}
+
+	{
+		// test: revalidation by modification time
+		h, svr := newServer(false)
+		defer h.Close()
+		defer svr.Close()
+
+		res := resize(svr.URL, "file:///photos/a.jpg", http.StatusOK)
+		require.NotEmpty(t, res.Header.Get("Last-Modified"))
+		require.Equal(t, int64(1), h.Stats().SourceLoads)
+		require.Equal(t, int64(1), h.Stats().Loads)
+
+		clk.Advance(2 * time.Minute)
+		resize(svr.URL, "file:///photos/a.jpg", http.StatusOK)
+		require.Equal(t, int64(2), h.Stats().SourceLoads)
+		require.Equal(t, int64(1), h.Stats().SourceRevalidations)
+		require.Equal(t, int64(1), h.Stats().Loads)
+
+		modified := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(root, "photos", "a.jpg"), modified, modified))
+
+		clk.Advance(2 * time.Minute)
+		resize(svr.URL, "file:///photos/a.jpg", http.StatusOK)
+		require.Equal(t, int64(3), h.Stats().SourceLoads)
+		require.Equal(t, int64(1), h.Stats().SourceRevalidations)
+		require.Equal(t, int64(2), h.Stats().Loads)
+	}
+
+	{
+		// test: file source is disabled
+		cfg := helperConfig()
+		h := NewWithConfig(cfg)
+		defer h.Close()
+
+		svr := httptest.NewServer(h.Mux())
+		defer svr.Close()
+
+		resize(svr.URL, "file:///photos/a.jpg", http.StatusBadRequest)
+	}
+}
+
+func TestIsInside(t *testing.T) {
+
+	sep := string(filepath.Separator)
+
+	for _, tc := range []struct {
+		name string
+		exp  bool
+	}{
+		{name: sep + "root", exp: true},
+		{name: sep + "root" + sep + "a", exp: true},
+		{name: sep + "root" + sep + ".." + "a", exp: true},
+		{name: sep + "rootx", exp: false},
+		{name: sep + "other" + sep + "a", exp: false},
+		{name: sep, exp: false},
+	} {
+		require.Equal(t, tc.exp, isInside(sep+"root", tc.name), tc.name)
+	}
+}
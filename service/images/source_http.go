@@ -0,0 +1,252 @@
+package images
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/khevse/image-resizer/service/images/internal/breaker"
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/fetch"
+)
+
+// httpSource loads source images from HTTP(S) resources with settings of their origin
+type httpSource struct {
+	h      *Handler
+	origin *Origin // nil if all origins are allowed
+	base   string  // base URL of resource of origin, the image URL is requested if it's empty
+}
+
+func (s httpSource) load(ctx context.Context, reqURL string, validators cache.Meta) (result, error) {
+
+	target, err := s.target(reqURL)
+	if err != nil {
+		return result{}, newHTTPError(400, "invalid resource URL:"+err.Error())
+	}
+
+	// the invalid URLs of clients aren't counted by the breakers
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return result{}, newHTTPError(400, "invalid resource URL:unsupported scheme "+strconv.Quote(target.Scheme))
+	}
+
+	if target.Host == "" {
+		return result{}, newHTTPError(400, "invalid resource URL:no host")
+	}
+
+	return s.h.guard(ctx, target.Host, func() (result, error) {
+		return s.request(ctx, reqURL, target.String(), validators)
+	})
+}
+
+// target returns URL of request, the path of image URL is appended to the base URL
+func (s httpSource) target(reqURL string) (*url.URL, error) {
+
+	u, err := url.Parse(reqURL)
+	if err != nil || s.base == "" {
+		return u, err
+	}
+
+	base, err := url.Parse(s.base)
+	if err != nil {
+		return nil, err
+	}
+
+	target := *u
+	target.Scheme = base.Scheme
+	target.User = base.User
+	target.Host = base.Host
+	target.Path = strings.TrimSuffix(base.Path, "/") + path.Clean("/"+u.Path)
+	target.RawPath = ""
+
+	return &target, nil
+}
+
+// request loads source image from resource by target URL, the request is conditional if there are validators
+func (s httpSource) request(ctx context.Context, reqURL, targetURL string, validators cache.Meta) (result, error) {
+
+	log.Println("send from resource")
+	atomic.AddInt64(&s.h.stats.SourceLoads, 1)
+
+	reqForLoad, err := http.NewRequest(http.MethodGet, targetURL, nil)
+	if err != nil {
+		return result{}, newHTTPError(400, "failed to create request:"+err.Error())
+	}
+
+	if s.origin != nil {
+		setOriginHeader(reqForLoad, s.origin)
+	}
+
+	for name, values := range conditionalHeader(validators) {
+		reqForLoad.Header[name] = values
+	}
+
+	ctx, cancel := s.h.originContext(ctx, s.origin)
+	defer cancel()
+
+	res, err := s.h.client.Do(reqForLoad.WithContext(ctx))
+	if err != nil {
+		return result{}, requestError(ctx, err)
+	}
+
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.Println("ERROR:", err)
+		}
+	}()
+
+	return s.h.readResponse(ctx, reqURL, res, validators, s.h.originMaxSourceSize(s.origin))
+}
+
+// guard calls fn if the circuit breaker of host is closed, the result of fn is reported to the breaker,
+// the canceled requests and unknown errors only release the slot of request
+func (h *Handler) guard(ctx context.Context, host string, fn func() (result, error)) (result, error) {
+
+	done, err := h.breakers.Acquire(host)
+	if err != nil {
+		atomic.AddInt64(&h.stats.BreakerRejects, 1)
+		return result{}, breakerError(err)
+	}
+
+	src, err := fn()
+
+	e, ok := err.(*httpError)
+	switch {
+	case err == nil || (ok && !e.failure && ctx.Err() == nil):
+		done(breaker.Success)
+	case ok && e.failure:
+		done(breaker.Failure)
+	default:
+		// the request is canceled by client, the state of host is unknown
+		done(breaker.Canceled)
+	}
+
+	return src, err
+}
+
+// originContext returns context with timeout of the origin
+func (h *Handler) originContext(ctx context.Context, o *Origin) (context.Context, context.CancelFunc) {
+
+	if o != nil && o.Timeout > 0 {
+		return context.WithTimeout(ctx, o.Timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// originMaxSourceSize returns max size of source image of the origin
+func (h *Handler) originMaxSourceSize(o *Origin) int64 {
+
+	if o != nil && o.MaxSourceSize > 0 {
+		return o.MaxSourceSize
+	}
+
+	return h.maxSourceSize
+}
+
+// conditionalHeader returns headers of conditional request by validators of cached image
+func conditionalHeader(validators cache.Meta) http.Header {
+
+	header := make(http.Header)
+
+	if validators.ETag != "" {
+		header.Set("If-None-Match", validators.ETag)
+	}
+
+	if validators.LastModified != "" {
+		header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	return header
+}
+
+// requestError returns error of failed request to resource
+func requestError(ctx context.Context, err error) error {
+
+	if fetch.IsDenied(err) {
+		return newHTTPError(http.StatusForbidden, "forbidden resource:"+err.Error())
+	}
+
+	if isTimeout(err) {
+		e := newHTTPError(http.StatusGatewayTimeout, "resource timeout:"+err.Error())
+		e.negative = true
+		e.failure = true
+		return e
+	}
+
+	e := newHTTPError(400, "failed to send request:"+err.Error())
+	e.failure = ctx.Err() == nil && isConnectionError(err) // the request isn't canceled by client
+	return e
+}
+
+// readResponse returns source image from response of resource, the body is limited by max size of source image
+func (h *Handler) readResponse(ctx context.Context, reqURL string, res *http.Response, validators cache.Meta, maxSourceSize int64) (result, error) {
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+	case res.StatusCode == http.StatusNotModified && hasValidators(validators):
+		meta := validators
+		meta.Lifetime = h.upstreamLifetime(res.Header, h.cache.Now())
+		return result{meta: meta, notModified: true}, nil
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		e := newHTTPError(http.StatusNotFound, "resource not found:"+res.Status)
+		e.negative = true
+		return result{}, e
+	default:
+		e := newHTTPError(http.StatusBadGateway, "invalid response of resource:"+res.Status)
+		e.failure = res.StatusCode >= 500
+		return result{}, e
+	}
+
+	if maxSourceSize > 0 && res.ContentLength > maxSourceSize {
+		// the body isn't read
+		return result{}, sourceTooLarge(res.ContentLength, maxSourceSize)
+	}
+
+	var body io.Reader = res.Body
+	if maxSourceSize > 0 {
+		// the size of body may differ from Content-Length or it may be unknown
+		body = io.LimitReader(res.Body, maxSourceSize+1)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		if isTimeout(err) {
+			e := newHTTPError(http.StatusGatewayTimeout, "resource timeout:"+err.Error())
+			e.negative = true
+			e.failure = true
+			return result{}, e
+		}
+		e := newHTTPError(400, "failed to read response:"+err.Error())
+		e.failure = ctx.Err() == nil
+		return result{}, e
+	}
+
+	if maxSourceSize > 0 && int64(len(data)) > maxSourceSize {
+		return result{}, sourceTooLarge(int64(len(data)), maxSourceSize)
+	}
+
+	now := h.cache.Now()
+
+	meta := cache.Meta{
+		Source:       reqURL,
+		Tags:         upstreamTags(res.Header),
+		Lifetime:     h.upstreamLifetime(res.Header, now),
+		NoStore:      noStore(res.Header),
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Modified:     now,
+	}
+
+	if modified, err := http.ParseTime(meta.LastModified); err == nil && modified.Before(now) {
+		meta.Modified = modified
+	}
+
+	return result{data: data, meta: meta}, nil
+}
@@ -0,0 +1,96 @@
+package images
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/s3"
+)
+
+// S3Config - bucket of S3-compatible storage
+type S3Config = s3.Config
+
+// sinkTimeout - timeout of writing of resized image to the sink bucket
+const sinkTimeout = 30 * time.Second
+
+// s3Source loads source images of origin from bucket, the path of URL is the key of object
+type s3Source struct {
+	h      *Handler
+	origin *Origin
+	bucket *s3.Bucket
+}
+
+func (s s3Source) load(ctx context.Context, reqURL string, validators cache.Meta) (result, error) {
+
+	u, err := url.Parse(reqURL)
+	if err != nil {
+		return result{}, newHTTPError(400, "invalid resource URL:"+err.Error())
+	}
+
+	// the cleaned path can't leave the prefix of bucket
+	key := path.Clean("/" + u.Path)
+
+	return s.h.guard(ctx, s.bucket.Host(), func() (result, error) {
+		return s.request(ctx, reqURL, key, validators)
+	})
+}
+
+// request loads object from bucket, the request is conditional if there are validators
+func (s s3Source) request(ctx context.Context, reqURL, key string, validators cache.Meta) (result, error) {
+
+	log.Println("send from storage")
+	atomic.AddInt64(&s.h.stats.SourceLoads, 1)
+
+	ctx, cancel := s.h.originContext(ctx, s.origin)
+	defer cancel()
+
+	res, err := s.bucket.Get(ctx, key, conditionalHeader(validators))
+	if err != nil {
+		return result{}, requestError(ctx, err)
+	}
+
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.Println("ERROR:", err)
+		}
+	}()
+
+	return s.h.readResponse(ctx, reqURL, res, validators, s.h.originMaxSourceSize(s.origin))
+}
+
+// store writes resized image to the sink bucket in background, the key of object is the cache key,
+// the images aren't written after the handler is closed
+func (h *Handler) store(cacheKey string, data []byte) {
+
+	if h.sink == nil {
+		return
+	}
+
+	h.sinkMu.Lock()
+	defer h.sinkMu.Unlock()
+
+	if h.sinkClosed {
+		return
+	}
+
+	h.sinkWG.Add(1)
+	go func() {
+		defer h.sinkWG.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+		defer cancel()
+
+		if err := h.sink.Put(ctx, cacheKey+".jpg", data, "image/jpeg"); err != nil {
+			log.Println("ERROR: failed to write image to sink:", err)
+			atomic.AddInt64(&h.stats.SinkErrors, 1)
+			return
+		}
+
+		atomic.AddInt64(&h.stats.SinkWrites, 1)
+	}()
+}
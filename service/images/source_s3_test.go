@@ -0,0 +1,104 @@
+package images
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/khevse/image-resizer/service/images/internal/s3"
+	"github.com/khevse/image-resizer/service/images/internal/s3/s3test"
+	"github.com/khevse/image-resizer/service/images/internal/spec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeS3(t *testing.T) {
+
+	cred := s3.Credentials{AccessKey: "key", SecretKey: "secret"}
+
+	storage := s3test.NewFake(cred)
+	storageSvr := httptest.NewServer(storage)
+	defer storageSvr.Close()
+
+	sink := s3test.NewFake(cred)
+	sinkSvr := httptest.NewServer(sink)
+	defer sinkSvr.Close()
+
+	image := helperNewImage(t, 100, 100).Bytes()
+	storage.Put("images", "originals/photos/a.jpg", image, "image/jpeg")
+
+	clk := clock.NewFake(time.Now())
+
+	cfg := helperConfig()
+	cfg.Clock = clk
+	cfg.CacheLifetime = time.Minute
+	cfg.SourceCacheLifetime = time.Minute
+	cfg.NegativeCacheLifetime = 0
+	cfg.Origins = []Origin{
+		{
+			Host: "images.example.com",
+			S3:   &S3Config{Endpoint: storageSvr.URL, Bucket: "images", Prefix: "originals/", Credentials: cred},
+		},
+		{
+			Host: "private.example.com",
+			S3:   &S3Config{Endpoint: storageSvr.URL, Bucket: "images", Credentials: s3.Credentials{AccessKey: "key", SecretKey: "other"}},
+		},
+	}
+	cfg.Sink = &S3Config{Endpoint: sinkSvr.URL, Bucket: "resized", Credentials: cred}
+
+	h := NewWithConfig(cfg)
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	resize := func(sourceURL string, expStatus int) {
+		t.Helper()
+
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", sourceURL, "width", "10", "height", "10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, expStatus, res.StatusCode, sourceURL)
+	}
+
+	resize("http://images.example.com/photos/a.jpg", http.StatusOK)
+	resize("http://images.example.com/photos/a.jpg", http.StatusOK)
+	require.Equal(t, 1, storage.Requests())
+
+	// the key can't leave the prefix
+	resize("http://images.example.com/../photos/a.jpg", http.StatusOK)
+	require.Equal(t, 2, storage.Requests())
+
+	resize("http://images.example.com/photos/missing.jpg", http.StatusNotFound)
+	resize("http://private.example.com/photos/a.jpg", http.StatusBadGateway)
+	resize("http://other.example.com/photos/a.jpg", http.StatusForbidden)
+
+	// the expired source is revalidated by ETag
+	clk.Advance(2 * time.Minute)
+	resize("http://images.example.com/photos/a.jpg", http.StatusOK)
+	require.Equal(t, int64(1), h.Stats().SourceRevalidations)
+	require.Equal(t, int64(2), h.Stats().Loads)
+
+	// the resized images are written to the sink
+	require.NoError(t, h.Close())
+	require.Equal(t, int64(2), h.Stats().SinkWrites)
+	require.Equal(t, int64(0), h.Stats().SinkErrors)
+
+	cacheKey := cache.NewKey(spec.New("http://images.example.com/photos/a.jpg", 10, 10).String())
+	data, contentType, ok := sink.Get("resized", cacheKey+".jpg")
+	require.True(t, ok)
+	require.Equal(t, "image/jpeg", contentType)
+	require.NotEmpty(t, data)
+
+	// the images aren't written after the handler is closed
+	h.store("closed", data)
+	_, _, ok = sink.Get("resized", "closed.jpg")
+	require.False(t, ok)
+	require.Equal(t, int64(2), h.Stats().SinkWrites)
+}
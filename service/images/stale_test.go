@@ -0,0 +1,255 @@
+package images
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/khevse/image-resizer/service/images/internal/spec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeStale(t *testing.T) {
+
+	var (
+		sourceRequests int32
+		sourceStatus   int32 // status of failing source
+	)
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+
+		if status := atomic.LoadInt32(&sourceStatus); status > 0 {
+			http.Error(w, "failure", int(status))
+			return
+		}
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	advance := clk.Advance
+
+	cfg := helperConfig()
+	cfg.CacheLifetime = time.Minute
+	cfg.SourceCacheLifetime = time.Minute
+	cfg.StaleWhileRevalidate = time.Minute
+	cfg.StaleIfError = time.Hour
+	cfg.NegativeCacheLifetime = 0
+	cfg.Clock = clk
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", source.URL, "width", "10", "height", "10")
+
+	resize := func(expStatus int, expCacheControl string) {
+		t.Helper()
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, expStatus, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+
+		if expStatus == http.StatusOK {
+			require.Equal(t, expCacheControl, res.Header.Get("Cache-Control"))
+		}
+	}
+
+	const (
+		Fresh = "max-age=60, stale-while-revalidate=60, stale-if-error=3600"
+		Stale = "max-age=0, stale-while-revalidate=60, stale-if-error=3600"
+	)
+
+	resize(http.StatusOK, Fresh)
+	require.Equal(t, int32(1), atomic.LoadInt32(&sourceRequests))
+
+	{
+		// test: stale while revalidate
+		advance(time.Minute + time.Second)
+		resize(http.StatusOK, Stale)
+
+		cacheKey := cache.NewKey(spec.New(source.URL, 10, 10).String())
+		for {
+			if _, ok := h.cache.Get(cacheKey); ok {
+				break
+			}
+			time.Sleep(time.Millisecond) // wait for refresh in background
+		}
+
+		resize(http.StatusOK, Fresh)
+		require.Equal(t, int32(2), atomic.LoadInt32(&sourceRequests))
+	}
+
+	{
+		// test: stale if error
+		atomic.StoreInt32(&sourceStatus, http.StatusInternalServerError)
+		advance(3 * time.Minute)
+
+		resize(http.StatusOK, Stale)
+		require.Equal(t, int32(3), atomic.LoadInt32(&sourceRequests))
+	}
+
+	{
+		// test: stale image isn't sent if the resource isn't failing
+		atomic.StoreInt32(&sourceStatus, http.StatusNotFound)
+		resize(http.StatusNotFound, "")
+
+		atomic.StoreInt32(&sourceStatus, http.StatusForbidden)
+		resize(http.StatusBadGateway, "")
+		atomic.StoreInt32(&sourceStatus, http.StatusInternalServerError)
+	}
+
+	{
+		// test: stale if error is over
+		advance(time.Hour)
+		resize(http.StatusBadGateway, "")
+	}
+
+	stats := h.Stats()
+	require.Equal(t, int64(1), stats.StaleHits)
+	require.Equal(t, int64(1), stats.StaleErrors)
+	require.Equal(t, int64(1), stats.CacheHits)
+}
+
+func TestResizeStaleNegativeCache(t *testing.T) {
+
+	var (
+		sourceRequests int32
+		sourceTimeout  int32 // the source doesn't respond in time
+	)
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+
+		if atomic.LoadInt32(&sourceTimeout) > 0 {
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cfg := helperConfig()
+	cfg.CacheLifetime = time.Minute
+	cfg.SourceCacheLifetime = time.Minute
+	cfg.StaleIfError = time.Hour
+	cfg.NegativeCacheLifetime = time.Minute
+	cfg.FetchHeaderTimeout = 50 * time.Millisecond
+	cfg.Clock = clk
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", source.URL, "width", "10", "height", "10")
+
+	resize := func() {
+		t.Helper()
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	resize()
+
+	atomic.StoreInt32(&sourceTimeout, 1)
+	clk.Advance(2 * time.Minute)
+
+	// the timeout of resource is stored to negative cache
+	resize()
+	require.Equal(t, int32(2), atomic.LoadInt32(&sourceRequests))
+
+	// the failure from negative cache sends the stale image as well
+	resize()
+	require.Equal(t, int32(2), atomic.LoadInt32(&sourceRequests))
+
+	stats := h.Stats()
+	require.Equal(t, int64(2), stats.StaleErrors)
+	require.Equal(t, int64(1), stats.NegativeHits)
+}
+
+func TestResizeStaleRefreshOnce(t *testing.T) {
+
+	var sourceRequests int32
+	unblock := make(chan struct{})
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&sourceRequests, 1) > 1 {
+			<-unblock // the refresh is blocked
+		}
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cfg := helperConfig()
+	cfg.CacheLifetime = time.Minute
+	cfg.SourceCacheLifetime = time.Minute
+	cfg.StaleWhileRevalidate = time.Minute
+	cfg.Clock = clk
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", source.URL, "width", "10", "height", "10")
+
+	for i := 0; i < 3; i++ {
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+
+		if i == 0 {
+			clk.Advance(time.Minute + time.Second)
+		}
+	}
+
+	reqSpec := spec.New(source.URL, 10, 10)
+	cacheKey := cache.NewKey(reqSpec.String())
+	require.False(t, h.startRefresh(cacheKey, reqSpec))
+
+	close(unblock)
+	for {
+		if _, ok := h.cache.Get(cacheKey); ok {
+			break
+		}
+		time.Sleep(time.Millisecond) // wait for refresh in background
+	}
+
+	require.Equal(t, int32(2), atomic.LoadInt32(&sourceRequests))
+	require.Equal(t, int64(2), h.Stats().StaleHits)
+}
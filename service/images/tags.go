@@ -0,0 +1,126 @@
+package images
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxTags - max number of tags of one image
+const maxTags = 50
+
+// parseTags parses comma separated tags of resize request
+func parseTags(src string) ([]string, error) {
+
+	if src == "" {
+		return nil, nil
+	}
+
+	tags := strings.Split(src, ",")
+	if len(tags) > maxTags {
+		return nil, errors.New("invalid property tags: too many tags")
+	}
+
+	for _, tag := range tags {
+		if !validTag(tag) {
+			return nil, errors.New("invalid property tags: invalid tag " + tag)
+		}
+	}
+
+	return mergeTags(tags), nil
+}
+
+// upstreamTags returns tags from Surrogate-Key (space separated) and Cache-Tag (comma separated) headers
+func upstreamTags(header http.Header) []string {
+
+	var tags []string
+	for _, v := range header["Surrogate-Key"] {
+		tags = append(tags, strings.Fields(v)...)
+	}
+
+	for _, v := range header["Cache-Tag"] {
+		for _, tag := range strings.Split(v, ",") {
+			tags = append(tags, strings.TrimSpace(tag))
+		}
+	}
+
+	valid := tags[:0]
+	for _, tag := range tags {
+		if validTag(tag) && len(valid) < maxTags {
+			valid = append(valid, tag)
+		}
+	}
+
+	return mergeTags(valid)
+}
+
+// mergeTags returns sorted list of unique tags
+func mergeTags(lists ...[]string) []string {
+
+	unique := make(map[string]struct{})
+	for _, list := range lists {
+		for _, tag := range list {
+			unique[tag] = struct{}{}
+		}
+	}
+
+	if len(unique) == 0 {
+		return nil
+	}
+
+	tags := make([]string, 0, len(unique))
+	for tag := range unique {
+		tags = append(tags, tag)
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
+
+// addTags returns sorted list of unique tags of the image with the request tags,
+// the request tags aren't added beyond max number of tags
+func addTags(tags, reqTags []string) []string {
+
+	unique := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		unique[tag] = struct{}{}
+	}
+
+	added := make([]string, 0, len(reqTags))
+	for _, tag := range mergeTags(reqTags) {
+		if len(unique) >= maxTags {
+			break
+		}
+		if _, ok := unique[tag]; !ok {
+			unique[tag] = struct{}{}
+			added = append(added, tag)
+		}
+	}
+
+	return mergeTags(tags, added)
+}
+
+// setSurrogateKey writes tags to Surrogate-Key header of response
+func setSurrogateKey(w http.ResponseWriter, tags []string) {
+	if len(tags) > 0 {
+		w.Header().Set("Surrogate-Key", strings.Join(tags, " "))
+	}
+}
+
+// validTag returns true if the tag is not empty and contains only printable characters without spaces and commas
+func validTag(tag string) bool {
+
+	if tag == "" || len(tag) > 256 {
+		return false
+	}
+
+	for _, r := range tag {
+		if r <= ' ' || r == ',' || r >= 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
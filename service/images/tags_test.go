@@ -0,0 +1,144 @@
+package images
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/spec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeTags(t *testing.T) {
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Surrogate-Key", "product-1  photo-"+req.URL.Path[1:])
+		w.Header().Set("Cache-Tag", "cdn, product-1")
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	cfg := helperConfig()
+	cfg.AdminToken = testAdminToken
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	resize := func(sourceURL, size, tags string) string {
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", sourceURL, "width", size, "height", size, "tags", tags)
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+
+		return res.Header.Get("Surrogate-Key")
+	}
+
+	require.Equal(t, "cdn photo-a product-1 user-1", resize(source.URL+"/a", "10", "user-1"))
+	require.Equal(t, "cdn photo-a product-1", resize(source.URL+"/a", "20", ""))
+	require.Equal(t, "cdn photo-b product-1", resize(source.URL+"/b", "10", ""))
+
+	// test: tags are added to cached image
+	require.Equal(t, "cdn photo-a product-1 user-1 user-2", resize(source.URL+"/a", "10", "user-2"))
+	require.Equal(t, "cdn photo-a product-1 user-1 user-2", resize(source.URL+"/a", "10", ""))
+
+	{
+		// test: tags of cached image are limited
+		var tags []string
+		for i := 0; i < maxTags; i++ {
+			tags = append(tags, "t-"+strconv.Itoa(i))
+		}
+		resize(source.URL+"/a", "10", strings.Join(tags, ","))
+
+		_, meta, ok := h.cache.Lookup(cache.NewKey(spec.New(source.URL+"/a", 10, 10).String()))
+		require.True(t, ok)
+		require.Len(t, meta.Tags, maxTags)
+		require.Len(t, strings.Fields(resize(source.URL+"/a", "10", "")), maxTags)
+	}
+
+	{
+		// test: invalid tags
+		u, err := url.Parse(svr.URL + "/resize")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL, "width", "10", "height", "10", "tags", "a,,b")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+		require.Equal(t, "invalid property tags: invalid tag \n", helperGetStringFromBody(t, res))
+	}
+
+	{
+		// test: purge by tag
+		var res AdminPurgeResult
+		helperAdmin(t, http.MethodPost, svr.URL+"/admin/purge?tag=user-2", &res)
+		require.Equal(t, 1, res.Purged)
+
+		helperAdmin(t, http.MethodPost, svr.URL+"/admin/purge?tag=photo-a", &res)
+		require.Equal(t, 2, res.Purged) // derivative and source
+
+		helperAdmin(t, http.MethodPost, svr.URL+"/admin/purge?tag=product-1", &res)
+		require.Equal(t, 2, res.Purged)
+
+		var stats AdminStats
+		helperAdmin(t, http.MethodGet, svr.URL+"/admin/stats", &stats)
+		require.Equal(t, 0, stats.Cache.Entries)
+		require.Equal(t, 0, stats.SourceCache.Entries)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+
+	tags, err := parseTags("b,a,b")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, tags)
+
+	tags, err = parseTags("")
+	require.NoError(t, err)
+	require.Nil(t, tags)
+
+	_, err = parseTags("a b")
+	require.EqualError(t, err, "invalid property tags: invalid tag a b")
+}
+
+func TestAddTags(t *testing.T) {
+
+	require.Nil(t, addTags(nil, nil))
+	require.Equal(t, []string{"a", "b", "c"}, addTags([]string{"c", "a"}, []string{"b", "a"}))
+
+	var tags []string
+	for i := 0; i < maxTags-1; i++ {
+		tags = append(tags, "t-"+strconv.Itoa(i))
+	}
+
+	merged := addTags(tags, []string{"b", "a"})
+	require.Len(t, merged, maxTags)
+	require.Contains(t, merged, "a")
+	require.NotContains(t, merged, "b")
+}
+
+func TestUpstreamTags(t *testing.T) {
+
+	header := make(http.Header)
+	require.Nil(t, upstreamTags(header))
+
+	header.Add("Surrogate-Key", " k1 k2 ")
+	header.Add("Surrogate-Key", "k3")
+	header.Add("Cache-Tag", "t1, k1,,")
+
+	require.Equal(t, []string{"k1", "k2", "k3", "t1"}, upstreamTags(header))
+}
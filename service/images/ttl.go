@@ -0,0 +1,132 @@
+package images
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upstreamLifetime returns lifetime of the source image from Cache-Control and Expires headers of resource,
+// clamped to the configured limits. Returns zero if the resource doesn't set lifetime.
+// The images with no-store, no-cache or private directives aren't cached, see noStore.
+func (h *Handler) upstreamLifetime(header http.Header, now time.Time) time.Duration {
+
+	lifetime, ok := parseLifetime(header, now)
+	if !ok {
+		return 0
+	}
+
+	if lifetime < h.minLifetime {
+		lifetime = h.minLifetime
+	}
+
+	if h.maxLifetime > 0 && lifetime > h.maxLifetime {
+		lifetime = h.maxLifetime
+	}
+
+	if lifetime <= 0 {
+		// the zero lifetime means the default lifetime of cache
+		lifetime = time.Second
+	}
+
+	return lifetime
+}
+
+// noStore returns true if Cache-Control header forbids storing of the image in shared caches
+func noStore(header http.Header) bool {
+
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			if i := strings.Index(directive, "="); i >= 0 {
+				directive = directive[:i]
+			}
+
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-store", "no-cache", "private":
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// maxDeltaSeconds - max value of delta-seconds of Cache-Control header, the greater values are clamped (RFC 7234)
+const maxDeltaSeconds = 1 << 31
+
+// parseDeltaSeconds returns duration from delta-seconds, the huge values are clamped to avoid overflow
+func parseDeltaSeconds(arg string) (time.Duration, bool) {
+
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange && !strings.HasPrefix(arg, "-") {
+		seconds, err = maxDeltaSeconds, nil
+	}
+
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	if seconds > maxDeltaSeconds {
+		seconds = maxDeltaSeconds
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// parseLifetime returns lifetime from Cache-Control (s-maxage, max-age, no-store, no-cache, private)
+// or Expires headers, ok is false if there is no lifetime in the headers.
+func parseLifetime(header http.Header, now time.Time) (lifetime time.Duration, ok bool) {
+
+	var maxAge, sharedMaxAge time.Duration = -1, -1
+
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, arg = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "no-store", "no-cache", "private":
+				return 0, true
+			case "max-age":
+				if age, ok := parseDeltaSeconds(arg); ok {
+					maxAge = age
+				}
+			case "s-maxage":
+				if age, ok := parseDeltaSeconds(arg); ok {
+					sharedMaxAge = age
+				}
+			}
+		}
+	}
+
+	// s-maxage is for shared caches, like the service
+	if sharedMaxAge >= 0 {
+		return sharedMaxAge, true
+	} else if maxAge >= 0 {
+		return maxAge, true
+	}
+
+	expiresValue := header.Get("Expires")
+	if expiresValue == "" {
+		return 0, false
+	}
+
+	expires, err := http.ParseTime(expiresValue)
+	if err != nil {
+		return 0, true // invalid value means already expired
+	}
+
+	// the clock of the resource may differ, so the lifetime is calculated relatively of its Date header
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		now = date
+	}
+
+	if lifetime = expires.Sub(now); lifetime < 0 {
+		lifetime = 0
+	}
+
+	return lifetime, true
+}
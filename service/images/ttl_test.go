@@ -0,0 +1,201 @@
+package images
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/internal/cache"
+	"github.com/khevse/image-resizer/service/images/internal/clock"
+	"github.com/khevse/image-resizer/service/images/internal/spec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamLifetime(t *testing.T) {
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	h := &Handler{
+		minLifetime: time.Minute,
+		maxLifetime: time.Hour,
+	}
+
+	testCases := []struct {
+		Name   string
+		Header http.Header
+		Exp    time.Duration
+	}{
+		{"no headers", http.Header{}, 0},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=120"}}, 2 * time.Minute},
+		{"s-maxage", http.Header{"Cache-Control": {"max-age=120, s-maxage=300"}}, 5 * time.Minute},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, time.Minute},
+		{"private", http.Header{"Cache-Control": {"private, max-age=600"}}, time.Minute},
+		{"min", http.Header{"Cache-Control": {"max-age=1"}}, time.Minute},
+		{"max", http.Header{"Cache-Control": {"max-age=86400"}}, time.Hour},
+		{"invalid max-age", http.Header{"Cache-Control": {"max-age=abc"}}, 0},
+		{"huge max-age", http.Header{"Cache-Control": {"max-age=9223372036854775807"}}, time.Hour},
+		{"overflowing s-maxage", http.Header{"Cache-Control": {"s-maxage=99999999999999999999"}}, time.Hour},
+		{"negative max-age", http.Header{"Cache-Control": {"max-age=-99999999999999999999"}}, 0},
+		{"expires", http.Header{"Expires": {now.Add(10 * time.Minute).Format(http.TimeFormat)}}, 10 * time.Minute},
+		{
+			"expires with date",
+			http.Header{
+				"Date":    {now.Add(-5 * time.Minute).Format(http.TimeFormat)},
+				"Expires": {now.Add(10 * time.Minute).Format(http.TimeFormat)},
+			},
+			15 * time.Minute,
+		},
+		{"invalid expires", http.Header{"Expires": {"0"}}, time.Minute},
+		{
+			"max-age over expires",
+			http.Header{
+				"Cache-Control": {"max-age=120"},
+				"Expires":       {now.Add(10 * time.Minute).Format(http.TimeFormat)},
+			},
+			2 * time.Minute,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Exp, h.upstreamLifetime(tc.Header, now))
+		})
+	}
+}
+
+func TestNoStore(t *testing.T) {
+
+	for value, exp := range map[string]bool{
+		"":                       false,
+		"public, max-age=120":    false,
+		"no-store":               true,
+		"No-Cache":               true,
+		"max-age=600, private":   true,
+		`private="Set-Cookie"`:   true,
+		"must-revalidate, proxy": false,
+	} {
+		require.Equal(t, exp, noStore(http.Header{"Cache-Control": {value}}), value)
+	}
+}
+
+func TestParseDeltaSeconds(t *testing.T) {
+
+	for arg, exp := range map[string]time.Duration{
+		"0":                    0,
+		"120":                  2 * time.Minute,
+		"9223372036854775807":  maxDeltaSeconds * time.Second,
+		"99999999999999999999": maxDeltaSeconds * time.Second,
+	} {
+		age, ok := parseDeltaSeconds(arg)
+		require.True(t, ok, arg)
+		require.Equal(t, exp, age, arg)
+	}
+
+	for _, arg := range []string{"", "abc", "-1", "-99999999999999999999"} {
+		_, ok := parseDeltaSeconds(arg)
+		require.False(t, ok, arg)
+	}
+}
+
+func TestResizeUpstreamLifetime(t *testing.T) {
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=120")
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cfg := helperConfig()
+	cfg.Clock = clk
+
+	h := NewWithConfig(cfg)
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	u, err := url.Parse(svr.URL + "/resize")
+	require.NoError(t, err)
+	helperSetQuery(u, "url", source.URL, "width", "10", "height", "10")
+
+	res, err := http.Get(u.String())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, "max-age=120", res.Header.Get("Cache-Control"))
+
+	cacheKey := cache.NewKey(spec.New(source.URL, 10, 10).String())
+
+	clk.Advance(time.Minute)
+	_, ok := h.cache.Get(cacheKey)
+	require.True(t, ok)
+
+	{
+		// test: max-age of cached image in batch is its remaining lifetime
+		u, err := url.Parse(svr.URL + "/resize/batch")
+		require.NoError(t, err)
+		helperSetQuery(u, "url", source.URL, "sizes", "10x10")
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, "max-age=60", res.Header.Get("Cache-Control"))
+	}
+
+	clk.Advance(time.Minute)
+	_, ok = h.cache.Get(cacheKey)
+	require.False(t, ok)
+}
+
+func TestResizeNoStore(t *testing.T) {
+
+	var sourceRequests int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&sourceRequests, 1)
+		w.Header().Set("Cache-Control", "private, max-age=600")
+
+		buf := helperNewImage(t, 100, 100)
+		_, err := io.Copy(w, buf)
+		require.NoError(t, err)
+	}))
+	defer source.Close()
+
+	h := helperNew()
+	defer h.Close()
+
+	svr := httptest.NewServer(h.Mux())
+	defer svr.Close()
+
+	get := func(path string, query ...string) {
+		t.Helper()
+
+		u, err := url.Parse(svr.URL + path)
+		require.NoError(t, err)
+		helperSetQuery(u, query...)
+
+		res, err := http.Get(u.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+	}
+
+	get("/resize", "url", source.URL, "width", "10", "height", "10")
+	get("/resize", "url", source.URL, "width", "10", "height", "10")
+	get("/resize/batch", "url", source.URL, "sizes", "10x10,20x20")
+
+	// the images are loaded from resource on each request
+	require.Equal(t, int32(3), atomic.LoadInt32(&sourceRequests))
+	require.Equal(t, 0, h.cache.Stats().Entries)
+	require.Equal(t, 0, h.sourceCache.Stats().Entries)
+}
@@ -0,0 +1,85 @@
+package warmup
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Task - image for warm-up
+type Task struct {
+	URL    string `json:"url"`
+	Width  uint   `json:"width"`
+	Height uint   `json:"height"`
+}
+
+func (t Task) String() string {
+	return fmt.Sprintf("%s %dx%d", t.URL, t.Width, t.Height)
+}
+
+// ParseManifest reads list of tasks, each line is JSON object ({"url":"...","width":100,"height":100})
+// or text "<url> <width> <height>". Empty lines and lines started with '#' are skipped.
+func ParseManifest(r io.Reader) ([]Task, error) {
+
+	var tasks []Task
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		task, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func parseLine(line string) (Task, error) {
+
+	var task Task
+
+	if strings.HasPrefix(line, "{") {
+		if err := json.Unmarshal([]byte(line), &task); err != nil {
+			return task, err
+		}
+	} else {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return task, fmt.Errorf("expected <url> <width> <height>")
+		}
+
+		width, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return task, fmt.Errorf("invalid width: %v", err)
+		}
+
+		height, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return task, fmt.Errorf("invalid height: %v", err)
+		}
+
+		task = Task{URL: fields[0], Width: uint(width), Height: uint(height)}
+	}
+
+	if task.URL == "" {
+		return task, fmt.Errorf("empty url")
+	} else if task.Width == 0 || task.Height == 0 {
+		return task, fmt.Errorf("invalid size %dx%d", task.Width, task.Height)
+	}
+
+	return task, nil
+}
@@ -0,0 +1,137 @@
+package warmup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/signature"
+)
+
+// Failure - failed task
+type Failure struct {
+	Task Task
+	Err  error
+}
+
+// Report - result of warm-up
+type Report struct {
+	Total    int
+	Failures []Failure
+	Duration time.Duration // total duration
+	Min      time.Duration // min duration of one task
+	Max      time.Duration // max duration of one task
+	Avg      time.Duration // average duration of one task
+}
+
+// Run sends resize requests for all tasks to the service (e.g. http://localhost:8000)
+// with at most concurrency requests at the same time, the requests are signed by the key if its secret isn't empty.
+func Run(ctx context.Context, client *http.Client, service string, key signature.Key, tasks []Task, concurrency int) Report {
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		report = Report{Total: len(tasks)}
+		total  time.Duration
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+
+	queue := make(chan Task)
+	started := time.Now()
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for task := range queue {
+				taskStarted := time.Now()
+				err := resize(ctx, client, service, key, task)
+				duration := time.Since(taskStarted)
+
+				mu.Lock()
+				if err != nil {
+					report.Failures = append(report.Failures, Failure{Task: task, Err: err})
+				}
+
+				total += duration
+				if report.Min == 0 || duration < report.Min {
+					report.Min = duration
+				}
+				if duration > report.Max {
+					report.Max = duration
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, task := range tasks {
+		queue <- task
+	}
+
+	close(queue)
+	wg.Wait()
+
+	report.Duration = time.Since(started)
+	if len(tasks) > 0 {
+		report.Avg = total / time.Duration(len(tasks))
+	}
+
+	return report
+}
+
+func resize(ctx context.Context, client *http.Client, service string, key signature.Key, task Task) error {
+
+	reqURL, err := resizeURL(service, key, task)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	_, err = io.Copy(ioutil.Discard, res.Body)
+
+	return err
+}
+
+// resizeURL returns URL of resize request of the task, it's signed if the secret of key isn't empty
+func resizeURL(service string, key signature.Key, task Task) (string, error) {
+
+	base := strings.TrimRight(service, "/") + "/resize"
+	if len(key.Secret) > 0 {
+		return signature.ResizeURL(base, key, task.URL, task.Width, task.Height, time.Time{})
+	}
+
+	q := url.Values{}
+	q.Set("url", task.URL)
+	q.Set("width", strconv.FormatUint(uint64(task.Width), 10))
+	q.Set("height", strconv.FormatUint(uint64(task.Height), 10))
+
+	return base + "?" + q.Encode(), nil
+}
@@ -0,0 +1,126 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/khevse/image-resizer/service/images/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseManifest(t *testing.T) {
+
+	tasks, err := ParseManifest(strings.NewReader(`
+# comment
+http://example.com/a.jpg 100 200
+{"url":"http://example.com/b.jpg","width":300,"height":400}
+`))
+	require.NoError(t, err)
+	require.Equal(t,
+		[]Task{
+			{URL: "http://example.com/a.jpg", Width: 100, Height: 200},
+			{URL: "http://example.com/b.jpg", Width: 300, Height: 400},
+		},
+		tasks)
+
+	for src, errMsg := range map[string]string{
+		"http://example.com/a.jpg 100":       "line 1: expected <url> <width> <height>",
+		"http://example.com/a.jpg a 100":     `line 1: invalid width: strconv.ParseUint: parsing "a": invalid syntax`,
+		"\nhttp://example.com/a.jpg 100 -1":  `line 2: invalid height: strconv.ParseUint: parsing "-1": invalid syntax`,
+		`{"url":"http://example.com/a.jpg"}`: "line 1: invalid size 0x0",
+		`{"width":1,"height":1}`:             "line 1: empty url",
+		`{"url":"http://example.com/a.jpg",`: "line 1: unexpected end of JSON input",
+	} {
+		_, err := ParseManifest(strings.NewReader(src))
+		require.EqualError(t, err, errMsg, src)
+	}
+}
+
+func TestRun(t *testing.T) {
+
+	var (
+		requests []string
+		mu       sync.Mutex
+	)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "/resize", req.URL.Path)
+
+		mu.Lock()
+		requests = append(requests, req.URL.RawQuery)
+		mu.Unlock()
+
+		if req.URL.Query().Get("url") == "http://example.com/bad.jpg" {
+			http.Error(w, "internal server error:image: unknown format", 500)
+			return
+		}
+
+		w.Write([]byte("image"))
+	}))
+	defer svr.Close()
+
+	tasks := []Task{
+		{URL: "http://example.com/a.jpg", Width: 10, Height: 20},
+		{URL: "http://example.com/bad.jpg", Width: 10, Height: 10},
+		{URL: "http://example.com/c.jpg", Width: 30, Height: 40},
+	}
+
+	report := Run(context.Background(), svr.Client(), svr.URL+"/", signature.Key{}, tasks, 2)
+
+	require.Equal(t, 3, report.Total)
+	require.Equal(t,
+		[]Failure{
+			{
+				Task: tasks[1],
+				Err:  errors.New("500 Internal Server Error: internal server error:image: unknown format"),
+			},
+		},
+		report.Failures)
+	require.True(t, report.Min > 0 && report.Min <= report.Avg && report.Avg <= report.Max)
+
+	require.ElementsMatch(t,
+		[]string{
+			"height=20&url=http%3A%2F%2Fexample.com%2Fa.jpg&width=10",
+			"height=10&url=http%3A%2F%2Fexample.com%2Fbad.jpg&width=10",
+			"height=40&url=http%3A%2F%2Fexample.com%2Fc.jpg&width=30",
+		},
+		requests)
+}
+
+func TestRunSigned(t *testing.T) {
+
+	key := signature.Key{ID: "k1", Secret: []byte("secret")}
+	keys := signature.Keys{key.ID: key.Secret}
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		width, err := strconv.ParseUint(q.Get("width"), 10, 64)
+		require.NoError(t, err)
+		height, err := strconv.ParseUint(q.Get("height"), 10, 64)
+		require.NoError(t, err)
+
+		message := signature.ResizeMessage(q.Get("url"), uint(width), uint(height))
+		if err := keys.Verify(q, message, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		w.Write([]byte("image"))
+	}))
+	defer svr.Close()
+
+	tasks := []Task{{URL: "http://example.com/a.jpg", Width: 10, Height: 20}}
+
+	report := Run(context.Background(), svr.Client(), svr.URL, key, tasks, 1)
+	require.Empty(t, report.Failures)
+
+	report = Run(context.Background(), svr.Client(), svr.URL, signature.Key{}, tasks, 1)
+	require.Equal(t, []Failure{{Task: tasks[0], Err: errors.New("403 Forbidden: signature is missing")}}, report.Failures)
+}